@@ -1,78 +1,176 @@
 package main
 
 import (
-	"log"
+	"io"
 	"os"
 	"runtime"
+	"strings"
 	"time"
 
-	"github.com/operator-framework/operator-sdk/pkg/ansible/operator"
-	proxy "github.com/operator-framework/operator-sdk/pkg/ansible/proxy"
 	k8sutil "github.com/operator-framework/operator-sdk/pkg/k8sutil"
 	sdkVersion "github.com/operator-framework/operator-sdk/version"
 	"github.com/spf13/pflag"
+	"github.com/water-hole/ansible-operator/pkg/ansible/controller"
+	"github.com/water-hole/ansible-operator/pkg/ansible/events"
+	"github.com/water-hole/ansible-operator/pkg/ansible/operator"
+	"github.com/water-hole/ansible-operator/pkg/ansible/paramconv"
+	proxy "github.com/water-hole/ansible-operator/pkg/ansible/proxy"
+	"github.com/water-hole/ansible-operator/pkg/ansible/proxy/kubeconfig"
+	"github.com/water-hole/ansible-operator/pkg/ansible/runner"
+	"github.com/water-hole/ansible-operator/pkg/log/zap"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
-
-	"github.com/sirupsen/logrus"
 )
 
-var defaultReconcilePeriod = pflag.String("reconcile-period", "1m", "default reconcile period for controllers")
+var log = logf.Log.WithName("cmd")
+
+var (
+	defaultReconcilePeriod = pflag.String("reconcile-period", "1m", "default reconcile period for controllers")
+	auditLog               = pflag.String("proxy-audit-log", "", "write a JSON line for every mutating request made through the ansible proxy to this file, or \"-\" for stdout")
+	auditRequestBodies     = pflag.Bool("proxy-audit-request-bodies", false, "include request bodies, with Secret data redacted, in the proxy audit log")
+	proxyQPS               = pflag.Float32("proxy-qps", 0, "maximum queries per second the ansible proxy sends to the API server, 0 for no limit")
+	proxyBurst             = pflag.Int("proxy-burst", 10, "maximum burst of queries the ansible proxy sends to the API server")
+	proxyJobQPS            = pflag.Float32("proxy-job-qps", 0, "maximum queries per second a single ansible run may send through the proxy, 0 for no limit")
+	proxyJobBurst          = pflag.Int("proxy-job-burst", 5, "maximum burst of queries a single ansible run may send through the proxy")
+	dryRun                 = pflag.Bool("dry-run", false, "run every reconciliation in ansible check mode with server-side dry run writes")
+	acronyms               = pflag.StringSlice("acronyms", paramconv.DefaultAcronyms, "words written in upper case when converting the snake_case status keys of a run to camelCase, e.g. HTTP,URL,IP,TLS,DNS,ID")
+	ansibleLogEvents       = pflag.String("ansible-log-events", "tasks", "how ansible events are logged: tasks, everything, nothing, or stdout to print the human readable ansible output as plain text on stdout")
+)
 
 func printVersion() {
-	logrus.Infof("Go Version: %s", runtime.Version())
-	logrus.Infof("Go OS/Arch: %s/%s", runtime.GOOS, runtime.GOARCH)
-	logrus.Infof("operator-sdk Version: %v", sdkVersion.Version)
+	log.Info("Go Version", "version", runtime.Version())
+	log.Info("Go OS/Arch", "os", runtime.GOOS, "arch", runtime.GOARCH)
+	log.Info("operator-sdk Version", "version", sdkVersion.Version)
+}
+
+// fatal logs the error and exits.
+func fatal(err error, msg string) {
+	log.Error(err, msg)
+	os.Exit(1)
+}
+
+// splitNamespaces splits the comma separated namespaces of WATCH_NAMESPACE.
+// An empty value means all namespaces and returns none.
+func splitNamespaces(s string) []string {
+	namespaces := []string{}
+	seen := map[string]bool{}
+	for _, ns := range strings.Split(s, ",") {
+		ns = strings.TrimSpace(ns)
+		if ns == "" || seen[ns] {
+			continue
+		}
+		seen[ns] = true
+		namespaces = append(namespaces, ns)
+	}
+	return namespaces
 }
 
 func main() {
+	pflag.CommandLine.AddFlagSet(zap.FlagSet())
 	pflag.Parse()
-	logf.SetLogger(logf.ZapLogger(false))
+	logf.SetLogger(zap.Logger())
+	paramconv.SetAcronyms(*acronyms)
 
 	d, err := time.ParseDuration(*defaultReconcilePeriod)
 	if err != nil {
-		logrus.Fatalf("failed to parse reconcile-period: %v", err)
+		fatal(err, "failed to parse reconcile-period")
+	}
+
+	logEvents, err := events.ParseLogLevel(*ansibleLogEvents)
+	if err != nil {
+		fatal(err, "failed to parse ansible-log-events")
 	}
 
-	namespace, found := os.LookupEnv(k8sutil.WatchNamespaceEnvVar)
-	if found {
-		logrus.Infof("Watching %v namespace.", namespace)
+	namespaces := []string{}
+	if ns, found := os.LookupEnv(k8sutil.WatchNamespaceEnvVar); found {
+		namespaces = splitNamespaces(ns)
+		log.Info("Watching namespaces.", "namespaces", namespaces)
 	} else {
-		logrus.Infof("%v environment variable not set. This operator is watching all namespaces.",
-			k8sutil.WatchNamespaceEnvVar)
+		log.Info("Environment variable not set, watching all namespaces", "variable", k8sutil.WatchNamespaceEnvVar)
 	}
 
-	mgr, err := manager.New(config.GetConfigOrDie(), manager.Options{
-		Namespace: namespace,
-	})
+	// The cache of the manager holds every namespace, it is only used when
+	// the operator watches all of them or for cluster-scoped kinds. The
+	// watched namespaces each have their own cache.
+	mgr, err := manager.New(config.GetConfigOrDie(), manager.Options{})
+	if err != nil {
+		fatal(err, "failed to create manager")
+	}
+	caches, err := controller.NewCaches(mgr, namespaces)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err, "failed to create caches")
 	}
 
 	printVersion()
 	done := make(chan error)
 
+	var auditOut io.Writer
+	switch *auditLog {
+	case "":
+	case "-":
+		auditOut = os.Stdout
+	default:
+		f, err := os.OpenFile(*auditLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			fatal(err, "failed to open proxy-audit-log")
+		}
+		defer f.Close()
+		auditOut = f
+	}
+
+	watches, err := runner.NewFromWatches("/opt/ansible/watches.yaml")
+	if err != nil {
+		fatal(err, "failed to get watches")
+	}
+	// The informers of the watched kinds only hold the selected CRs, so the
+	// proxy reads them from the API server.
+	uncachedKinds := []schema.GroupKind{}
+	for gvk := range watches {
+		uncachedKinds = append(uncachedKinds, gvk.GroupKind())
+	}
+
+	// The proxy only serves the runs the controllers register.
+	runs := kubeconfig.NewRuns()
+
 	// start the proxy
 	err = proxy.Run(done, proxy.Options{
-		Address:    "localhost",
-		Port:       8888,
-		KubeConfig: mgr.GetConfig(),
-		RESTMapper: mgr.GetRESTMapper(),
-		Cache:      mgr.GetCache(),
+		Address:            "localhost",
+		Port:               8888,
+		KubeConfig:         mgr.GetConfig(),
+		RESTMapper:         mgr.GetRESTMapper(),
+		Cache:              caches.ProxyReader(),
+		UncachedKinds:      uncachedKinds,
+		AuditLog:           auditOut,
+		AuditRequestBodies: *auditRequestBodies,
+		RateLimit: proxy.RateLimit{
+			QPS:      *proxyQPS,
+			Burst:    *proxyBurst,
+			JobQPS:   *proxyJobQPS,
+			JobBurst: *proxyJobBurst,
+		},
+		Runs: runs,
 	})
 	if err != nil {
-		logrus.Fatalf("error starting proxy: %v", err)
+		fatal(err, "error starting proxy")
 	}
 
 	// start the operator
-	go operator.Run(done, mgr, "/opt/ansible/watches.yaml", d)
+	go operator.Run(done, mgr, operator.Options{
+		Watches:         watches,
+		ReconcilePeriod: d,
+		DryRun:          *dryRun,
+		LoggingLevel:    logEvents,
+		Runs:            runs,
+		Caches:          caches,
+	})
 
 	// wait for either to finish
 	err = <-done
 	if err == nil {
-		logrus.Info("Exiting")
+		log.Info("Exiting")
 	} else {
-		logrus.Fatal(err.Error())
+		fatal(err, "operator or proxy stopped")
 	}
 }
@@ -0,0 +1,184 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"sync"
+
+	"github.com/water-hole/ansible-operator/pkg/ansible/proxy"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// Caches - the informer caches the controllers read Secrets and ConfigMaps
+// from, one for each namespace. A cache of controller-runtime 0.1.8 holds one
+// namespace or all of them, and the manager has no option to replace its
+// own, so when the operator watches some namespaces the caches of those are
+// added to the manager as runnables. The cache of the manager is only used
+// when the operator watches every namespace.
+type Caches struct {
+	mgr manager.Manager
+	// namespaces are the namespaces the operator watches, empty for all.
+	namespaces []string
+	apiReader  client.Reader
+	mutex      sync.Mutex
+	caches     map[string]*namespaceCache
+}
+
+// namespaceCache - the cache of a namespace, whose reads wait until it has
+// synced, since the controllers only wait for the cache of the manager.
+type namespaceCache struct {
+	cache.Cache
+	synced chan struct{}
+}
+
+// Start - implements manager.Runnable.
+func (c *namespaceCache) Start(stop <-chan struct{}) error {
+	go func() {
+		if c.WaitForCacheSync(stop) {
+			close(c.synced)
+		}
+	}()
+	return c.Cache.Start(stop)
+}
+
+// Get - implements client.Reader.
+func (c *namespaceCache) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	<-c.synced
+	return c.Cache.Get(ctx, key, obj)
+}
+
+// List - implements client.Reader.
+func (c *namespaceCache) List(ctx context.Context, opts *client.ListOptions, list runtime.Object) error {
+	<-c.synced
+	return c.Cache.List(ctx, opts, list)
+}
+
+// NewCaches - returns the Caches of the namespaces the operator watches,
+// empty for all of them. It must be called before the manager starts.
+func NewCaches(mgr manager.Manager, namespaces []string) (*Caches, error) {
+	apiReader, err := client.New(mgr.GetConfig(), client.Options{Scheme: mgr.GetScheme(), Mapper: mgr.GetRESTMapper()})
+	if err != nil {
+		return nil, err
+	}
+	c := &Caches{
+		mgr:        mgr,
+		namespaces: namespaces,
+		apiReader:  apiReader,
+		caches:     map[string]*namespaceCache{},
+	}
+	for _, ns := range namespaces {
+		if _, err := c.ForNamespace(ns); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Namespaces - the namespaces the operator watches, empty for all.
+func (c *Caches) Namespaces() []string {
+	return c.namespaces
+}
+
+// ForNamespace - returns the cache of the namespace, adding it to the
+// manager if it is new. An empty namespace is every namespace, which only
+// the cache of the manager holds. New caches must be added before the
+// manager starts.
+func (c *Caches) ForNamespace(namespace string) (cache.Cache, error) {
+	if namespace == "" {
+		return c.mgr.GetCache(), nil
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if nc, ok := c.caches[namespace]; ok {
+		return nc, nil
+	}
+	nsCache, err := cache.New(c.mgr.GetConfig(), cache.Options{
+		Scheme:    c.mgr.GetScheme(),
+		Mapper:    c.mgr.GetRESTMapper(),
+		Namespace: namespace,
+	})
+	if err != nil {
+		return nil, err
+	}
+	nc := &namespaceCache{Cache: nsCache, synced: make(chan struct{})}
+	if err := c.mgr.Add(nc); err != nil {
+		return nil, err
+	}
+	c.caches[namespace] = nc
+	return nc, nil
+}
+
+// reader - returns the reader of the namespace: its cache, or the cache of
+// the manager when the operator watches every namespace, or else the API
+// server.
+func (c *Caches) reader(namespace string) client.Reader {
+	if len(c.namespaces) == 0 {
+		return c.mgr.GetCache()
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if nc, ok := c.caches[namespace]; ok {
+		return nc
+	}
+	return c.apiReader
+}
+
+// Get - implements client.Reader.
+func (c *Caches) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	return c.reader(key.Namespace).Get(ctx, key, obj)
+}
+
+// List - implements client.Reader.
+func (c *Caches) List(ctx context.Context, opts *client.ListOptions, list runtime.Object) error {
+	namespace := ""
+	if opts != nil {
+		namespace = opts.Namespace
+	}
+	return c.reader(namespace).List(ctx, opts, list)
+}
+
+// Client - returns a client reading typed objects from the caches and
+// unstructured objects, such as the CRs, from the API server.
+func (c *Caches) Client() client.Client {
+	return client.DelegatingClient{
+		Reader: &client.DelegatingReader{
+			CacheReader:  c,
+			ClientReader: c.apiReader,
+		},
+		Writer:       c.mgr.GetClient(),
+		StatusClient: c.mgr.GetClient(),
+	}
+}
+
+// ProxyReader - returns the reader the proxy serves GET requests from: the
+// caches of the namespaces the operator watches, where objects of other
+// namespaces and cluster-scoped objects aren't found, or the cache of the
+// manager when the operator watches every namespace.
+func (c *Caches) ProxyReader() client.Reader {
+	if len(c.namespaces) == 0 {
+		return c.mgr.GetCache()
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	m := proxy.MultiNamespaceReader{}
+	for _, ns := range c.namespaces {
+		m[ns] = c.caches[ns]
+	}
+	return m
+}
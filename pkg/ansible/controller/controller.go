@@ -0,0 +1,359 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/water-hole/ansible-operator/pkg/ansible/events"
+	"github.com/water-hole/ansible-operator/pkg/ansible/proxy/kubeconfig"
+	"github.com/water-hole/ansible-operator/pkg/ansible/proxy/policy"
+	"github.com/water-hole/ansible-operator/pkg/ansible/runner"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	crthandler "sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+var log = logf.Log.WithName("ansible-controller")
+
+// Options - options for your controller
+type Options struct {
+	EventHandlers   []events.EventHandler
+	LoggingLevel    events.LogLevel
+	Runner          runner.Runner
+	GVK             schema.GroupVersionKind
+	ReconcilePeriod time.Duration
+	ManageStatus    bool
+	// ImpersonateServiceAccount is the default service account that runs
+	// are scoped to. Empty disables impersonation.
+	ImpersonateServiceAccount string
+	// Policy restricts the writes runs may make through the proxy.
+	Policy *policy.Policy
+	// DryRun runs every reconciliation as a dry run.
+	DryRun bool
+	// Selector and Namespaces restrict the CRs the controller reconciles.
+	// They default to every CR.
+	Selector   labels.Selector
+	Namespaces []string
+	// Backoff retries failed runs with an exponential backoff instead of
+	// the reconcile period.
+	Backoff *runner.Backoff
+	// Schedule runs the reconciliation of the resources on a cron schedule.
+	Schedule *runner.Schedule
+	// ClusterScoped is whether the resources are cluster-scoped. Namespaces
+	// don't restrict them.
+	ClusterScoped bool
+	// DefaultNamespace is the namespace the runs of cluster-scoped resources
+	// work in.
+	DefaultNamespace string
+	// ReadOnly leaves the spec and status of the resources alone, for kinds
+	// the operator doesn't own.
+	ReadOnly bool
+	// Runs registers the runs in progress with the proxy.
+	Runs *kubeconfig.Runs
+	// Caches are the caches of the namespaces the controller watches.
+	Caches *Caches
+}
+
+// Add - Creates a new ansible operator controller and adds it to the manager
+func Add(mgr manager.Manager, options Options) {
+	log.Info("Watching resource", "Options.Group", options.GVK.Group, "Options.Version", options.GVK.Version, "Options.Kind", options.GVK.Kind)
+	if options.EventHandlers == nil {
+		options.EventHandlers = []events.EventHandler{}
+	}
+	if options.ClusterScoped && len(options.Namespaces) != 0 {
+		log.Info("Namespaces don't restrict cluster scoped resources, ignoring them", "GVK", options.GVK.String(), "Namespaces", options.Namespaces)
+		options.Namespaces = nil
+	}
+
+	// The vault password Secret is read from the API, its namespace may be
+	// outside the caches.
+	aor := &AnsibleOperatorReconciler{
+		Client:                    options.Caches.Client(),
+		APIReader:                 options.Caches.apiReader,
+		GVK:                       options.GVK,
+		Runner:                    options.Runner,
+		EventHandlers:             options.EventHandlers,
+		LoggingLevel:              options.LoggingLevel,
+		ReconcilePeriod:           options.ReconcilePeriod,
+		ManageStatus:              options.ManageStatus,
+		ImpersonateServiceAccount: options.ImpersonateServiceAccount,
+		Policy:                    options.Policy,
+		DryRun:                    options.DryRun,
+		Selector:                  options.Selector,
+		Namespaces:                options.Namespaces,
+		Backoff:                   options.Backoff,
+		Schedule:                  options.Schedule,
+		DefaultNamespace:          options.DefaultNamespace,
+		ReadOnly:                  options.ReadOnly,
+		Runs:                      options.Runs,
+	}
+
+	// Register the GVK with the schema, unless it is a built-in kind the
+	// schema already has a type for.
+	if !mgr.GetScheme().Recognizes(options.GVK) {
+		mgr.GetScheme().AddKnownTypeWithName(options.GVK, &unstructured.Unstructured{})
+		metav1.AddToGroupVersion(mgr.GetScheme(), schema.GroupVersion{
+			Group:   options.GVK.Group,
+			Version: options.GVK.Version,
+		})
+	}
+
+	//Create new controller runtime controller and set the controller to watch GVK.
+	c, err := controller.New(controllerName(options.GVK), mgr, controller.Options{
+		Reconciler: aor,
+	})
+	if err != nil {
+		log.Error(err, "")
+		os.Exit(1)
+	}
+	namespaces := crNamespaces(options)
+	for _, ns := range namespaces {
+		src, err := crSource(mgr, options.GVK, ns, aor.selection())
+		if err != nil {
+			log.Error(err, "")
+			os.Exit(1)
+		}
+		if err := c.Watch(src, &crthandler.EnqueueRequestForObject{}, aor.selection().predicate(), changedPredicate()); err != nil {
+			log.Error(err, "")
+			os.Exit(1)
+		}
+	}
+	watchVarSources(c, options, aor.selection(), namespaces)
+	watchVaultPasswordSecret(c, mgr, options, aor.selection(), namespaces)
+}
+
+// crNamespaces - the namespaces the CRs of the controller are watched in:
+// those of the watch that the operator watches too, or every namespace for
+// cluster-scoped kinds. An empty namespace is every namespace.
+func crNamespaces(options Options) []string {
+	operatorNamespaces := options.Caches.Namespaces()
+	switch {
+	case options.ClusterScoped:
+		return []string{""}
+	case len(options.Namespaces) == 0 && len(operatorNamespaces) == 0:
+		return []string{""}
+	case len(options.Namespaces) == 0:
+		return operatorNamespaces
+	case len(operatorNamespaces) == 0:
+		return options.Namespaces
+	}
+	namespaces := []string{}
+	for _, ns := range options.Namespaces {
+		for _, operatorNs := range operatorNamespaces {
+			if ns == operatorNs {
+				namespaces = append(namespaces, ns)
+				break
+			}
+		}
+	}
+	if len(namespaces) == 0 {
+		log.Info("None of the namespaces of the watch are watched by the operator", "GVK", options.GVK.String(), "Namespaces", options.Namespaces)
+	}
+	return namespaces
+}
+
+// crSource - returns a source of the CRs of the namespace, from an informer
+// of its own that only lists and watches the CRs the label selector of the
+// selection matches. A controller with a finalizer watches every CR, since
+// the finalizer of a CR that left the selection must still run when it is
+// deleted; the selection then only filters the events.
+func crSource(mgr manager.Manager, gvk schema.GroupVersionKind, namespace string, sel selection) (source.Source, error) {
+	mapping, err := mgr.GetRESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, err
+	}
+	dc, err := dynamic.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return nil, err
+	}
+	resources := dc.Resource(mapping.Resource).Namespace(namespace)
+	labelSelector := ""
+	if sel.selector != nil && sel.finalizer == "" {
+		labelSelector = sel.selector.String()
+	}
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(gvk)
+	informer := toolscache.NewSharedIndexInformer(&toolscache.ListWatch{
+		ListFunc: func(o metav1.ListOptions) (runtime.Object, error) {
+			o.LabelSelector = labelSelector
+			return resources.List(o)
+		},
+		WatchFunc: func(o metav1.ListOptions) (watch.Interface, error) {
+			o.LabelSelector = labelSelector
+			return resources.Watch(o)
+		},
+	}, u, 0, toolscache.Indexers{})
+	err = mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+		informer.Run(stop)
+		return nil
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return &source.Informer{Informer: informer}, nil
+}
+
+// kindSource - returns a source of the objects of the kind of obj in the
+// cache of the namespace. The cache is injected before the controller
+// injects the cache of the manager, which only sets it when it is unset.
+func kindSource(caches *Caches, namespace string, obj runtime.Object) (source.Source, error) {
+	c, err := caches.ForNamespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+	src := &source.Kind{Type: obj}
+	if err := src.InjectCache(c); err != nil {
+		return nil, err
+	}
+	return src, nil
+}
+
+// changedPredicate - filters out the updates that only change the status of
+// a resource, like the status the controller writes after each run. They
+// would requeue the resource at once and defeat the RequeueAfter of the run,
+// e.g. the backoff delay of a failed run. Kinds that don't track their
+// generation, like ConfigMaps, pass every update.
+func changedPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if e.MetaOld == nil || e.MetaNew == nil || e.MetaNew.GetGeneration() == 0 {
+				return true
+			}
+			return e.MetaOld.GetGeneration() != e.MetaNew.GetGeneration() ||
+				!reflect.DeepEqual(e.MetaOld.GetAnnotations(), e.MetaNew.GetAnnotations()) ||
+				!reflect.DeepEqual(e.MetaOld.GetLabels(), e.MetaNew.GetLabels()) ||
+				!reflect.DeepEqual(e.MetaOld.GetFinalizers(), e.MetaNew.GetFinalizers()) ||
+				!reflect.DeepEqual(e.MetaOld.GetDeletionTimestamp(), e.MetaNew.GetDeletionTimestamp())
+		},
+	}
+}
+
+// controllerName - the name of the controller of gvk, unique among the
+// versions of a kind, e.g. "database-v1beta1-app.example.com-controller".
+func controllerName(gvk schema.GroupVersionKind) string {
+	parts := []string{strings.ToLower(gvk.Kind), gvk.Version}
+	if gvk.Group != "" {
+		parts = append(parts, gvk.Group)
+	}
+	return strings.Join(append(parts, "controller"), "-")
+}
+
+// watchVarSources requeues the CRs whose extra variables or environment
+// variables are read from a Secret or ConfigMap when it changes. They are
+// watched in the namespaces of the CRs, or in the default namespace of the
+// watch for cluster-scoped CRs.
+func watchVarSources(c controller.Controller, options Options, sel selection, namespaces []string) {
+	vars := append([]runner.VarSource{}, options.Runner.GetVars()...)
+	for name, e := range options.Runner.GetEnv() {
+		if e.SecretKeyRef != nil {
+			vars = append(vars, runner.VarSource{Name: name, SecretKeyRef: e.SecretKeyRef})
+		}
+	}
+	var secrets, configMaps bool
+	for _, v := range vars {
+		secrets = secrets || v.IsSecret()
+		configMaps = configMaps || !v.IsSecret()
+	}
+	for _, w := range []struct {
+		enabled bool
+		obj     runtime.Object
+	}{
+		{secrets, &corev1.Secret{}},
+		{configMaps, &corev1.ConfigMap{}},
+	} {
+		if !w.enabled {
+			continue
+		}
+		_, secret := w.obj.(*corev1.Secret)
+		mapper := &varsMapper{client: options.Caches.Client(), gvk: options.GVK, vars: vars, secret: secret, selection: sel}
+		sourceNamespaces := namespaces
+		if options.ClusterScoped {
+			mapper.namespace = options.DefaultNamespace
+			mapper.clusterScoped = true
+			sourceNamespaces = []string{options.DefaultNamespace}
+		}
+		for _, ns := range sourceNamespaces {
+			src, err := kindSource(options.Caches, ns, w.obj.DeepCopyObject())
+			if err != nil {
+				log.Error(err, "")
+				os.Exit(1)
+			}
+			if err := c.Watch(src, &crthandler.EnqueueRequestsFromMapFunc{ToRequests: mapper}); err != nil {
+				log.Error(err, "")
+				os.Exit(1)
+			}
+		}
+	}
+}
+
+// watchVaultPasswordSecret requeues the CRs when the Secret holding the vault
+// password of the watch changes. The Secret is watched on its own, its
+// namespace may be outside the caches.
+func watchVaultPasswordSecret(c controller.Controller, mgr manager.Manager, options Options, sel selection, namespaces []string) {
+	ref := options.Runner.GetVaultPasswordSecret()
+	if ref == nil {
+		return
+	}
+	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		log.Error(err, "")
+		os.Exit(1)
+	}
+	secrets := clientset.CoreV1().Secrets(ref.Namespace)
+	fieldSelector := fields.OneTermEqualSelector("metadata.name", ref.Name).String()
+	informer := toolscache.NewSharedIndexInformer(&toolscache.ListWatch{
+		ListFunc: func(o metav1.ListOptions) (runtime.Object, error) {
+			o.FieldSelector = fieldSelector
+			return secrets.List(o)
+		},
+		WatchFunc: func(o metav1.ListOptions) (watch.Interface, error) {
+			o.FieldSelector = fieldSelector
+			return secrets.Watch(o)
+		},
+	}, &corev1.Secret{}, 0, toolscache.Indexers{})
+	err = mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+		informer.Run(stop)
+		return nil
+	}))
+	if err != nil {
+		log.Error(err, "")
+		os.Exit(1)
+	}
+	mapper := &vaultMapper{client: options.Caches.Client(), gvk: options.GVK, selection: sel, namespaces: namespaces}
+	if err := c.Watch(&source.Informer{Informer: informer}, &crthandler.EnqueueRequestsFromMapFunc{ToRequests: mapper}); err != nil {
+		log.Error(err, "")
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,727 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	ansiblestatus "github.com/water-hole/ansible-operator/pkg/ansible/controller/status"
+	"github.com/water-hole/ansible-operator/pkg/ansible/events"
+	"github.com/water-hole/ansible-operator/pkg/ansible/proxy/kubeconfig"
+	"github.com/water-hole/ansible-operator/pkg/ansible/proxy/policy"
+	"github.com/water-hole/ansible-operator/pkg/ansible/runner"
+	"github.com/water-hole/ansible-operator/pkg/ansible/runner/eventapi"
+
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+const (
+	// ReconcilePeriodAnnotation - annotation used by a user to specify the reconcilation interval for the CR.
+	// To use create a CR with an annotation "ansible.operator-sdk/reconcile-period: 30s" or some other valid
+	// Duration. This will override the operators/or controllers reconcile period for that particular CR.
+	ReconcilePeriodAnnotation = "ansible.operator-sdk/reconcile-period"
+
+	// ServiceAccountAnnotation - annotation used by a user to specify the service account, in the
+	// namespace of the CR, that the run is scoped to. It is only honored when impersonation is
+	// enabled for the watch and overrides the watch's default service account.
+	ServiceAccountAnnotation = "ansible.operator-sdk/service-account"
+
+	// DryRunAnnotation - annotation used by a user to run the reconciliation of the CR as a dry run.
+	// To use create a CR with an annotation "ansible.operator-sdk/dry-run: true". Ansible then runs
+	// in check mode, every write it makes through the proxy is a server-side dry run, and the changes
+	// it reports are recorded in the dryRun field of the status.
+	DryRunAnnotation = "ansible.operator-sdk/dry-run"
+
+	// PausedAnnotation - annotation used by a user to suspend the reconciliation of the CR, e.g.
+	// "ansible.operator-sdk/paused: true". While it is set no run happens and no finalizer is
+	// added, but the finalizer still runs when the CR is deleted.
+	PausedAnnotation = "ansible.operator-sdk/paused"
+
+	// PauseFinalizerAnnotation - annotation used by a user to also suspend the finalizer of a
+	// paused CR, e.g. "ansible.operator-sdk/pause-finalizer: true". The deletion of the CR then
+	// waits until it is no longer paused.
+	PauseFinalizerAnnotation = "ansible.operator-sdk/pause-finalizer"
+
+	// ScheduleAnnotation - annotation used by a user to run the reconciliation of the CR on a cron
+	// schedule, e.g. "ansible.operator-sdk/schedule: 0 2 * * *", optionally prefixed with the time
+	// zone, e.g. "CRON_TZ=Europe/Paris 0 2 * * *". This overrides the schedule of the watch.
+	ScheduleAnnotation = "ansible.operator-sdk/schedule"
+
+	// TriggerAnnotation - annotation used by a user to run the reconciliation of the CR now,
+	// without changing its spec. Each new value, e.g. a timestamp, causes one run, even when the
+	// CR exceeded its backoff limit. The value of the last triggered run is recorded in the
+	// lastTrigger field of the status.
+	TriggerAnnotation = "ansible.operator-sdk/trigger"
+)
+
+// AnsibleOperatorReconciler - object to reconcile runner requests
+type AnsibleOperatorReconciler struct {
+	GVK                       schema.GroupVersionKind
+	Runner                    runner.Runner
+	Client                    client.Client
+	APIReader                 client.Reader
+	EventHandlers             []events.EventHandler
+	LoggingLevel              events.LogLevel
+	ReconcilePeriod           time.Duration
+	ManageStatus              bool
+	ImpersonateServiceAccount string
+	Policy                    *policy.Policy
+	DryRun                    bool
+	Selector                  labels.Selector
+	Namespaces                []string
+	Backoff                   *runner.Backoff
+	Schedule                  *runner.Schedule
+	DefaultNamespace          string
+	ReadOnly                  bool
+	Runs                      *kubeconfig.Runs
+}
+
+// runRecord - what markDone records about a run besides its result.
+type runRecord struct {
+	// generation is the generation of the resource the run reconciled.
+	generation int64
+	// trigger is the value of the trigger annotation the run processed.
+	trigger string
+	// schedule is the schedule of the resource, nil if it isn't scheduled.
+	schedule *ansiblestatus.ScheduleStatus
+}
+
+// selection - the CRs reconciled by r.
+func (r *AnsibleOperatorReconciler) selection() selection {
+	finalizer, _ := r.Runner.GetFinalizer()
+	return selection{selector: r.Selector, namespaces: r.Namespaces, finalizer: finalizer}
+}
+
+// Reconcile - handle the event.
+func (r *AnsibleOperatorReconciler) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(r.GVK)
+	err := r.Client.Get(context.TODO(), request.NamespacedName, u)
+	if apierrors.IsNotFound(err) {
+		return reconcile.Result{}, nil
+	}
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	// The CR may have left the selection since it was queued. Its finalizer
+	// still runs once it is deleted.
+	if !r.selection().handles(u) {
+		return reconcile.Result{}, nil
+	}
+
+	ident := strconv.Itoa(rand.Int())
+	logger := logf.Log.WithName("reconciler").WithValues(
+		"job", ident,
+		"name", u.GetName(),
+		"namespace", u.GetNamespace(),
+		"gvk", r.GVK.String(),
+	)
+
+	reconcileResult := reconcile.Result{RequeueAfter: r.ReconcilePeriod}
+	if ds, ok := u.GetAnnotations()[ReconcilePeriodAnnotation]; ok {
+		duration, err := time.ParseDuration(ds)
+		if err != nil {
+			return reconcileResult, err
+		}
+		reconcileResult.RequeueAfter = duration
+	}
+
+	dryRun := r.DryRun
+	if ds, ok := u.GetAnnotations()[DryRunAnnotation]; ok {
+		annotationDryRun, err := strconv.ParseBool(ds)
+		if err != nil {
+			return reconcileResult, err
+		}
+		dryRun = dryRun || annotationDryRun
+	}
+
+	deleted := u.GetDeletionTimestamp() != nil
+	paused, err := r.paused(u, deleted)
+	if err != nil {
+		return reconcileResult, err
+	}
+	if paused {
+		logger.V(1).Info("Reconciliation is paused")
+		if r.ManageStatus {
+			err = r.markPaused(u, request.NamespacedName)
+		}
+		return reconcile.Result{}, err
+	}
+	if r.ManageStatus {
+		err = r.markUnpaused(u, request.NamespacedName)
+		if err != nil {
+			return reconcileResult, err
+		}
+	}
+	cron, err := r.cronFor(u)
+	if err != nil {
+		return reconcileResult, err
+	}
+	trigger, triggered := r.manualTrigger(u)
+	if triggered {
+		logger.Info("Run triggered by annotation", "Trigger", trigger)
+	}
+	if !deleted && !triggered && r.backoffLimitExceeded(u) {
+		logger.V(1).Info("Backoff limit exceeded, waiting for the spec to change")
+		return reconcile.Result{}, nil
+	}
+	finalizer, finalizerExists := r.Runner.GetFinalizer()
+	pendingFinalizers := u.GetFinalizers()
+	// If the resource is being deleted we don't want to add the finalizer again
+	if finalizerExists && !deleted && !contains(pendingFinalizers, finalizer) {
+		logger.V(1).Info("Adding finalizer to resource", "Finalizer", finalizer)
+		finalizers := append(pendingFinalizers, finalizer)
+		u.SetFinalizers(finalizers)
+		err := r.Client.Update(context.TODO(), u)
+		if err != nil {
+			return reconcileResult, err
+		}
+	}
+	if !contains(pendingFinalizers, finalizer) && deleted {
+		logger.Info("Resource is terminated, skipping reconcilation")
+		return reconcile.Result{}, nil
+	}
+
+	spec := u.Object["spec"]
+	_, ok := spec.(map[string]interface{})
+	if !ok && !r.ReadOnly {
+		logger.V(1).Info("spec was not found")
+		u.Object["spec"] = map[string]interface{}{}
+		err = r.Client.Update(context.TODO(), u)
+		if err != nil {
+			return reconcileResult, err
+		}
+	}
+
+	reconcileContext := r.reconcileContext(u, deleted)
+	if triggered && !deleted {
+		reconcileContext.Trigger = runner.TriggerManual
+	}
+	record := runRecord{generation: u.GetGeneration(), trigger: trigger}
+	if cron != nil && r.ManageStatus && !deleted {
+		now := time.Now()
+		var run bool
+		run, record.schedule = r.scheduledRun(u, cron, reconcileContext, now)
+		if !run {
+			logger.V(1).Info("Waiting for the next scheduled run", "NextRun", record.schedule.NextRun.Time)
+			return reconcile.Result{RequeueAfter: untilNextRun(record.schedule, now)}, r.markScheduled(u, record.schedule)
+		}
+	}
+	// A dry run only reports what it would change, in status.dryRun.
+	if r.ManageStatus && !dryRun {
+		err = r.markRunning(u, request.NamespacedName)
+		if err != nil {
+			return reconcileResult, err
+		}
+	}
+
+	ownerRef := metav1.OwnerReference{
+		APIVersion: u.GetAPIVersion(),
+		Kind:       u.GetKind(),
+		Name:       u.GetName(),
+		UID:        u.GetUID(),
+	}
+
+	vars, secretVars, err := r.resolveVars(u)
+	if err != nil {
+		return reconcileResult, err
+	}
+	vaultPassword, err := r.resolveVaultPassword()
+	if err != nil {
+		return reconcileResult, err
+	}
+	secretEnv, err := r.resolveSecretEnv(u)
+	if err != nil {
+		return reconcileResult, err
+	}
+	runInfo, err := r.runInfo(ident, u, ownerRef, dryRun)
+	if err != nil {
+		return reconcileResult, err
+	}
+	// The proxy trusts the RunInfo registered for the token of the run, not
+	// what ansible sends.
+	token, err := r.Runs.Register(runInfo)
+	if err != nil {
+		return reconcileResult, err
+	}
+	defer r.Runs.Unregister(token)
+	kc, err := kubeconfig.Create(ownerRef, "http://localhost:8888", r.namespaceFor(u), token)
+	if err != nil {
+		return reconcileResult, err
+	}
+	defer os.Remove(kc.Name())
+	result, err := r.Runner.Run(ident, u, kc.Name(), runner.RunOptions{
+		DryRun:        dryRun,
+		Vars:          vars,
+		SecretVars:    secretVars,
+		VaultPassword: vaultPassword,
+		SecretEnv:     secretEnv,
+		Reconcile:     reconcileContext,
+	})
+	if err != nil {
+		return reconcileResult, err
+	}
+
+	// iterate events from ansible, looking for the final one
+	statusEvent := eventapi.StatusJobEvent{}
+	failureMessages := eventapi.FailureMessages{}
+	var dryRunResult *ansiblestatus.DryRunResult
+	if dryRun {
+		dryRunResult = &ansiblestatus.DryRunResult{Changes: []ansiblestatus.DryRunChange{}}
+	}
+	level := r.loggingLevel(result)
+	eventHandlers := append([]events.EventHandler{}, r.EventHandlers...)
+	if level != events.Stdout {
+		eventHandlers = append(eventHandlers, events.NewLoggingEventHandler(level))
+	}
+	stdoutHandler := events.NewLoggingEventHandler(events.Stdout)
+	for event := range result.Events() {
+		for _, eHandler := range eventHandlers {
+			go eHandler.Handle(ident, u, event)
+		}
+		// The output of ansible is printed in order, so that it can be
+		// followed.
+		if level == events.Stdout {
+			stdoutHandler.Handle(ident, u, event)
+		}
+		if event.Event == eventapi.EventPlaybookOnStats {
+			// convert to StatusJobEvent; would love a better way to do this
+			data, err := json.Marshal(event)
+			if err != nil {
+				return reconcile.Result{}, err
+			}
+			err = json.Unmarshal(data, &statusEvent)
+			if err != nil {
+				return reconcile.Result{}, err
+			}
+		}
+		if event.Event == eventapi.EventRunnerOnFailed {
+			failureMessages = append(failureMessages, event.GetFailedPlaybookMessage())
+		}
+		if dryRun {
+			if c, ok := ansiblestatus.NewDryRunChangeFromJobEvent(event); ok {
+				dryRunResult.Changes = append(dryRunResult.Changes, *c)
+			}
+		}
+	}
+	if dryRun {
+		dryRunResult.TimeOfCompletion = metav1.Now()
+		logger.Info("Dry run finished", "Changes", dryRunResult.Changes)
+	}
+	if statusEvent.Event == "" {
+		eventErr := errors.New("did not receive playbook_on_stats event")
+		stdout, err := result.Stdout()
+		if err != nil {
+			logger.Error(err, "failed to get ansible-runner stdout")
+			return reconcileResult, err
+		}
+		logger.Error(eventErr, stdout)
+		return reconcileResult, eventErr
+	}
+
+	// We only want to update the CustomResource once, so we'll track changes and do it at the end
+	runSuccessful := len(failureMessages) == 0
+	// The finalizer has run successfully, time to remove it. A dry run of
+	// the finalizer did not clean anything up, so the finalizer is kept.
+	if deleted && finalizerExists && runSuccessful && dryRun {
+		logger.Info("Finalizer ran as a dry run, keeping finalizer", "Finalizer", finalizer)
+		return reconcileResult, nil
+	}
+	if deleted && finalizerExists && runSuccessful {
+		finalizers := []string{}
+		for _, pendingFinalizer := range pendingFinalizers {
+			if pendingFinalizer != finalizer {
+				finalizers = append(finalizers, pendingFinalizer)
+			}
+		}
+		u.SetFinalizers(finalizers)
+		err := r.Client.Update(context.TODO(), u)
+		if err != nil {
+			return reconcileResult, err
+		}
+		return reconcileResult, nil
+	}
+	// With a schedule, the next slot replaces the reconcile period. Slots
+	// that came during the run are covered by it.
+	if cron != nil {
+		now := time.Now()
+		if record.schedule != nil {
+			next := metav1.NewTime(cron.Next(now))
+			record.schedule.NextRun = &next
+		}
+		if runSuccessful {
+			reconcileResult.RequeueAfter = cron.Next(now).Sub(now) + scheduleMargin
+		}
+	}
+	if r.ManageStatus && dryRun {
+		err = r.markDryRunDone(u, request.NamespacedName, dryRunResult)
+		if err != nil {
+			logger.Error(err, "failed to mark dry run done")
+		}
+		return reconcileResult, err
+	}
+	if r.ManageStatus {
+		var failures int
+		failures, err = r.markDone(u, request.NamespacedName, record, statusEvent, failureMessages, result.AnsibleOptions())
+		if err != nil {
+			logger.Error(err, "failed to mark status done")
+		}
+		if !runSuccessful && r.Backoff != nil && err == nil {
+			if r.Backoff.LimitExceeded(failures) {
+				logger.Info("Backoff limit exceeded, no longer retrying", "Failures", failures)
+				return reconcile.Result{}, nil
+			}
+			reconcileResult.RequeueAfter = r.Backoff.Delay(failures)
+			logger.Info("Run failed, backing off", "Failures", failures, "RequeueAfter", reconcileResult.RequeueAfter)
+		}
+	}
+	return reconcileResult, err
+}
+
+// paused - whether the reconciliation of the resource is paused. The
+// finalizer of a deleted resource only pauses with PauseFinalizerAnnotation.
+func (r *AnsibleOperatorReconciler) paused(u *unstructured.Unstructured, deleted bool) (bool, error) {
+	annotation := PausedAnnotation
+	if deleted {
+		annotation = PauseFinalizerAnnotation
+	}
+	v, ok := u.GetAnnotations()[annotation]
+	if !ok {
+		return false, nil
+	}
+	paused, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %v annotation: %v", annotation, err)
+	}
+	if deleted && paused {
+		// The finalizer only pauses with the resource.
+		return r.paused(u, false)
+	}
+	return paused, nil
+}
+
+// manualTrigger returns the value of the trigger annotation of the resource,
+// and whether it asks for a run that has not happened yet.
+func (r *AnsibleOperatorReconciler) manualTrigger(u *unstructured.Unstructured) (string, bool) {
+	trigger := u.GetAnnotations()[TriggerAnnotation]
+	if !r.ManageStatus || trigger == "" {
+		return trigger, false
+	}
+	statusMap, _ := u.Object["status"].(map[string]interface{})
+	crStatus := ansiblestatus.CreateFromMap(statusMap)
+	return trigger, trigger != crStatus.LastTrigger
+}
+
+// backoffLimitExceeded - whether the last runs of the resource exceeded the
+// backoff limit for its current spec.
+func (r *AnsibleOperatorReconciler) backoffLimitExceeded(u *unstructured.Unstructured) bool {
+	if !r.ManageStatus || r.Backoff == nil {
+		return false
+	}
+	statusMap, _ := u.Object["status"].(map[string]interface{})
+	crStatus := ansiblestatus.CreateFromMap(statusMap)
+	c := ansiblestatus.GetCondition(crStatus, ansiblestatus.FailureConditionType)
+	return c != nil && c.Reason == ansiblestatus.BackoffLimitExceededReason && crStatus.ObservedGeneration == u.GetGeneration()
+}
+
+// loggingLevel returns the level the events of a run are logged at. A run
+// with a raised ansible verbosity logs every event, so that it can be
+// debugged without raising the level for every resource.
+func (r *AnsibleOperatorReconciler) loggingLevel(result runner.RunResult) events.LogLevel {
+	if result.Verbosity() > r.Runner.GetVerbosity() && r.LoggingLevel != events.Stdout {
+		return events.Everything
+	}
+	return r.LoggingLevel
+}
+
+// runInfo builds the information the proxy needs to handle the requests of a
+// run for the resource.
+func (r *AnsibleOperatorReconciler) runInfo(ident string, u *unstructured.Unstructured, ownerRef metav1.OwnerReference, dryRun bool) (kubeconfig.RunInfo, error) {
+	info := kubeconfig.RunInfo{
+		Job:            ident,
+		Namespace:      r.namespaceFor(u),
+		Owner:          ownerRef,
+		OwnerNamespace: u.GetNamespace(),
+		Policy:         r.Policy,
+		DryRun:         dryRun,
+		Converter:      r.Runner.GetConverter(),
+	}
+	if r.ImpersonateServiceAccount == "" {
+		return info, nil
+	}
+	sa := r.ImpersonateServiceAccount
+	if a, ok := u.GetAnnotations()[ServiceAccountAnnotation]; ok {
+		if errs := validation.IsDNS1123Subdomain(a); len(errs) != 0 {
+			return info, fmt.Errorf("invalid %v annotation: %v", ServiceAccountAnnotation, strings.Join(errs, ", "))
+		}
+		sa = a
+	}
+	ns := info.Namespace
+	if ns == "" {
+		return info, errors.New("impersonation of cluster scoped resources needs the defaultNamespace of the watch")
+	}
+	info.ImpersonateUser = fmt.Sprintf("system:serviceaccount:%v:%v", ns, sa)
+	info.ImpersonateGroups = []string{
+		"system:serviceaccounts",
+		fmt.Sprintf("system:serviceaccounts:%v", ns),
+		"system:authenticated",
+	}
+	return info, nil
+}
+
+// namespaceFor - the namespace the runs for the resource work in.
+func (r *AnsibleOperatorReconciler) namespaceFor(u *unstructured.Unstructured) string {
+	return runner.NamespaceFor(u, r.DefaultNamespace)
+}
+
+// reconcileContext describes the reconciliation to the run from the status
+// the previous run left.
+func (r *AnsibleOperatorReconciler) reconcileContext(u *unstructured.Unstructured, deleted bool) *runner.ReconcileContext {
+	// The status is copied, since u is updated while the run is going on.
+	statusMap, _ := u.DeepCopy().Object["status"].(map[string]interface{})
+	rc := &runner.ReconcileContext{Status: statusMap}
+	if rc.Status == nil {
+		rc.Status = map[string]interface{}{}
+	}
+	if deleted {
+		rc.Trigger = runner.TriggerDelete
+	}
+	if !r.ManageStatus {
+		return rc
+	}
+	crStatus := ansiblestatus.CreateFromMap(statusMap)
+	rc.ConsecutiveFailures = crStatus.ConsecutiveFailures
+	var lastResult *ansiblestatus.AnsibleResult
+	if c := ansiblestatus.GetCondition(crStatus, ansiblestatus.FailureConditionType); c != nil {
+		lastResult = c.AnsibleResult
+		rc.LastFailureMessage = c.Message
+	} else if c := ansiblestatus.GetCondition(crStatus, ansiblestatus.RunningConditionType); c != nil {
+		lastResult = c.AnsibleResult
+	}
+	if lastResult != nil {
+		rc.LastResult = lastResult
+	}
+	switch {
+	case deleted:
+	case rc.LastResult == nil && crStatus.ObservedGeneration == 0:
+		rc.Trigger = runner.TriggerCreate
+	case crStatus.ObservedGeneration != u.GetGeneration():
+		rc.Trigger = runner.TriggerUpdate
+	default:
+		rc.Trigger = runner.TriggerResync
+	}
+	return rc
+}
+
+// markPaused sets the paused condition of the resource.
+func (r *AnsibleOperatorReconciler) markPaused(u *unstructured.Unstructured, namespacedName types.NamespacedName) error {
+	// Get the latest resource to prevent updating a stale status
+	err := r.Client.Get(context.TODO(), namespacedName, u)
+	if err != nil {
+		return err
+	}
+	statusMap, _ := u.Object["status"].(map[string]interface{})
+	crStatus := ansiblestatus.CreateFromMap(statusMap)
+	if ansiblestatus.GetCondition(crStatus, ansiblestatus.PausedConditionType) != nil {
+		return nil
+	}
+	c := ansiblestatus.NewCondition(
+		ansiblestatus.PausedConditionType,
+		v1.ConditionTrue,
+		nil,
+		ansiblestatus.PausedReason,
+		fmt.Sprintf("Reconciliation is paused by the %v annotation", PausedAnnotation),
+	)
+	ansiblestatus.SetCondition(&crStatus, *c)
+	u.Object["status"] = crStatus.GetJSONMap()
+	return r.Client.Status().Update(context.TODO(), u)
+}
+
+// markUnpaused removes the paused condition of a resource that is no
+// longer paused, whether it runs now or waits, e.g. for its schedule or past
+// its backoff limit.
+func (r *AnsibleOperatorReconciler) markUnpaused(u *unstructured.Unstructured, namespacedName types.NamespacedName) error {
+	statusMap, _ := u.Object["status"].(map[string]interface{})
+	if ansiblestatus.GetCondition(ansiblestatus.CreateFromMap(statusMap), ansiblestatus.PausedConditionType) == nil {
+		return nil
+	}
+	// Get the latest resource to prevent updating a stale status
+	err := r.Client.Get(context.TODO(), namespacedName, u)
+	if err != nil {
+		return err
+	}
+	statusMap, _ = u.Object["status"].(map[string]interface{})
+	crStatus := ansiblestatus.CreateFromMap(statusMap)
+	if ansiblestatus.GetCondition(crStatus, ansiblestatus.PausedConditionType) == nil {
+		return nil
+	}
+	ansiblestatus.RemoveCondition(&crStatus, ansiblestatus.PausedConditionType)
+	u.Object["status"] = crStatus.GetJSONMap()
+	return r.Client.Status().Update(context.TODO(), u)
+}
+
+func (r *AnsibleOperatorReconciler) markRunning(u *unstructured.Unstructured, namespacedName types.NamespacedName) error {
+	// Get the latest resource to prevent updating a stale status
+	err := r.Client.Get(context.TODO(), namespacedName, u)
+	if err != nil {
+		return err
+	}
+	statusInterface := u.Object["status"]
+	statusMap, _ := statusInterface.(map[string]interface{})
+	crStatus := ansiblestatus.CreateFromMap(statusMap)
+
+	// If there is no current status add that we are working on this resource.
+	errCond := ansiblestatus.GetCondition(crStatus, ansiblestatus.FailureConditionType)
+	succCond := ansiblestatus.GetCondition(crStatus, ansiblestatus.RunningConditionType)
+
+	// If the condition is currently running, making sure that the values are correct.
+	// If they are the same a no-op, if they are different then it is a good thing we
+	// are updating it.
+	// The resource is no longer paused.
+	pausedCond := ansiblestatus.GetCondition(crStatus, ansiblestatus.PausedConditionType)
+	ansiblestatus.RemoveCondition(&crStatus, ansiblestatus.PausedConditionType)
+	if pausedCond != nil || (errCond == nil && succCond == nil) || (succCond != nil && succCond.Reason != ansiblestatus.SuccessfulReason) {
+		c := ansiblestatus.NewCondition(
+			ansiblestatus.RunningConditionType,
+			v1.ConditionTrue,
+			nil,
+			ansiblestatus.RunningReason,
+			ansiblestatus.RunningMessage,
+		)
+		ansiblestatus.SetCondition(&crStatus, *c)
+		u.Object["status"] = crStatus.GetJSONMap()
+		err := r.Client.Status().Update(context.TODO(), u)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markDone records the result of the run in the status, and returns the
+// number of runs that failed in a row.
+func (r *AnsibleOperatorReconciler) markDone(u *unstructured.Unstructured, namespacedName types.NamespacedName, record runRecord, statusEvent eventapi.StatusJobEvent, failureMessages eventapi.FailureMessages, ansibleOptions runner.AnsibleOptions) (int, error) {
+	logger := logf.Log.WithName("markDone")
+	// Get the latest resource to prevent updating a stale status
+	err := r.Client.Get(context.TODO(), namespacedName, u)
+	if apierrors.IsNotFound(err) {
+		logger.Info("resource not found, assuming it was deleted", err)
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	statusInterface := u.Object["status"]
+	statusMap, _ := statusInterface.(map[string]interface{})
+	crStatus := ansiblestatus.CreateFromMap(statusMap)
+
+	runSuccessful := len(failureMessages) == 0
+	ansibleStatus := ansiblestatus.NewAnsibleResultFromStatusJobEvent(statusEvent)
+	ansibleStatus.Options = &ansibleOptions
+
+	if !runSuccessful {
+		// A new spec gets a new budget of retries.
+		if crStatus.ObservedGeneration != record.generation {
+			crStatus.ConsecutiveFailures = 0
+		}
+		crStatus.ConsecutiveFailures++
+		reason := ansiblestatus.FailedReason
+		message := strings.Join(failureMessages, "\n")
+		if r.Backoff != nil && r.Backoff.LimitExceeded(crStatus.ConsecutiveFailures) {
+			reason = ansiblestatus.BackoffLimitExceededReason
+			message = fmt.Sprintf("%v\nFailed %v times in a row, not retrying until the spec changes", message, crStatus.ConsecutiveFailures)
+		}
+		sc := ansiblestatus.GetCondition(crStatus, ansiblestatus.RunningConditionType)
+		sc.Status = v1.ConditionFalse
+		ansiblestatus.SetCondition(&crStatus, *sc)
+		c := ansiblestatus.NewCondition(
+			ansiblestatus.FailureConditionType,
+			v1.ConditionTrue,
+			ansibleStatus,
+			reason,
+			message,
+		)
+		ansiblestatus.SetCondition(&crStatus, *c)
+	} else {
+		c := ansiblestatus.NewCondition(
+			ansiblestatus.RunningConditionType,
+			v1.ConditionTrue,
+			ansibleStatus,
+			ansiblestatus.SuccessfulReason,
+			ansiblestatus.SuccessfulMessage,
+		)
+		// Remove the failure condition if set, because this completed successfully.
+		ansiblestatus.RemoveCondition(&crStatus, ansiblestatus.FailureConditionType)
+		ansiblestatus.SetCondition(&crStatus, *c)
+		crStatus.ConsecutiveFailures = 0
+	}
+	crStatus.ObservedGeneration = record.generation
+	crStatus.LastTrigger = record.trigger
+	crStatus.Schedule = record.schedule
+	// Only the last run reports what a dry run would change.
+	crStatus.DryRun = nil
+	// This needs the status subresource to be enabled by default.
+	u.Object["status"] = crStatus.GetJSONMap()
+
+	return crStatus.ConsecutiveFailures, r.Client.Status().Update(context.TODO(), u)
+}
+
+// markDryRunDone - records the changes a dry run reported. The rest of the
+// status is left as the last real run left it.
+func (r *AnsibleOperatorReconciler) markDryRunDone(u *unstructured.Unstructured, namespacedName types.NamespacedName, dryRunResult *ansiblestatus.DryRunResult) error {
+	logger := logf.Log.WithName("markDryRunDone")
+	// Get the latest resource to prevent updating a stale status
+	err := r.Client.Get(context.TODO(), namespacedName, u)
+	if apierrors.IsNotFound(err) {
+		logger.Info("resource not found, assuming it was deleted", err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	statusInterface := u.Object["status"]
+	statusMap, _ := statusInterface.(map[string]interface{})
+	crStatus := ansiblestatus.CreateFromMap(statusMap)
+	crStatus.DryRun = dryRunResult
+	u.Object["status"] = crStatus.GetJSONMap()
+	return r.Client.Status().Update(context.TODO(), u)
+}
+
+func contains(l []string, s string) bool {
+	for _, elem := range l {
+		if elem == s {
+			return true
+		}
+	}
+	return false
+}
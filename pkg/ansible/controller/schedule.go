@@ -0,0 +1,113 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	ansiblestatus "github.com/water-hole/ansible-operator/pkg/ansible/controller/status"
+	"github.com/water-hole/ansible-operator/pkg/ansible/runner"
+	"github.com/water-hole/ansible-operator/pkg/ansible/schedule"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// catchUpTolerance is how late a slot may run with the CatchUpSkip policy.
+const catchUpTolerance = time.Minute
+
+// scheduleMargin delays the requeue for a slot, so that the slot has passed
+// when the resource is reconciled.
+const scheduleMargin = time.Second
+
+// cronFor returns the schedule of the resource: its schedule annotation, or
+// the schedule of the watch. Nil if the resource isn't scheduled.
+func (r *AnsibleOperatorReconciler) cronFor(u *unstructured.Unstructured) (*schedule.Cron, error) {
+	if r.Schedule == nil {
+		return nil, nil
+	}
+	if v, ok := u.GetAnnotations()[ScheduleAnnotation]; ok {
+		c, err := schedule.Parse(v, r.Schedule.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v annotation: %v", ScheduleAnnotation, err)
+		}
+		return c, nil
+	}
+	return r.Schedule.Cron, nil
+}
+
+// scheduledRun decides whether a scheduled resource runs now. It returns
+// whether it runs, and the status of its schedule afterwards. A resource
+// runs when it was created, changed, triggered or failed, as well as when a
+// slot of its schedule has come.
+func (r *AnsibleOperatorReconciler) scheduledRun(u *unstructured.Unstructured, cron *schedule.Cron, rc *runner.ReconcileContext, now time.Time) (bool, *ansiblestatus.ScheduleStatus) {
+	statusMap, _ := u.Object["status"].(map[string]interface{})
+	prev := ansiblestatus.CreateFromMap(statusMap).Schedule
+	next := metav1.NewTime(cron.Next(now))
+	ss := &ansiblestatus.ScheduleStatus{Cron: cron.String(), NextRun: &next}
+	// A slot computed for another schedule doesn't count.
+	if prev == nil || prev.Cron != cron.String() {
+		prev = nil
+	}
+	if prev != nil {
+		ss.LastRun = prev.LastRun
+	}
+	due := prev != nil && prev.NextRun != nil && !now.Before(prev.NextRun.Time)
+	if due && r.Schedule.CatchUp == runner.CatchUpSkip && now.Sub(prev.NextRun.Time) > catchUpTolerance {
+		log.Info("Skipping missed scheduled run", "name", u.GetName(), "namespace", u.GetNamespace(), "Slot", prev.NextRun.Time)
+		due = false
+	}
+	if due {
+		ss.LastRun = prev.NextRun
+		return true, ss
+	}
+	switch rc.Trigger {
+	case runner.TriggerCreate, runner.TriggerUpdate, runner.TriggerManual, runner.TriggerDelete:
+		return true, ss
+	}
+	return rc.ConsecutiveFailures > 0, ss
+}
+
+// untilNextRun is the requeue delay for the next slot of the schedule.
+func untilNextRun(ss *ansiblestatus.ScheduleStatus, now time.Time) time.Duration {
+	if ss == nil || ss.NextRun == nil {
+		return 0
+	}
+	return ss.NextRun.Time.Sub(now) + scheduleMargin
+}
+
+// markScheduled records the next slot of a resource that didn't run.
+func (r *AnsibleOperatorReconciler) markScheduled(u *unstructured.Unstructured, ss *ansiblestatus.ScheduleStatus) error {
+	// Get the latest resource to prevent updating a stale status
+	err := r.Client.Get(context.TODO(), types.NamespacedName{Namespace: u.GetNamespace(), Name: u.GetName()}, u)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	statusMap, _ := u.Object["status"].(map[string]interface{})
+	crStatus := ansiblestatus.CreateFromMap(statusMap)
+	if prev := crStatus.Schedule; prev != nil && prev.Cron == ss.Cron && prev.NextRun != nil && prev.NextRun.Equal(ss.NextRun) {
+		return nil
+	}
+	crStatus.Schedule = ss
+	u.Object["status"] = crStatus.GetJSONMap()
+	return r.Client.Status().Update(context.TODO(), u)
+}
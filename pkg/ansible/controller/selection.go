@@ -0,0 +1,85 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// selection - the CRs a controller reconciles, by label and namespace.
+type selection struct {
+	// selector matches the labels of the CRs, nil matches every CR.
+	selector labels.Selector
+	// namespaces are the namespaces of the CRs, empty matches every
+	// namespace.
+	namespaces []string
+	// finalizer is the finalizer of the controller, empty if it has none.
+	finalizer string
+}
+
+// selects - whether the CR is reconciled by the controller.
+func (s selection) selects(o metav1.Object) bool {
+	if s.selector != nil && !s.selector.Matches(labels.Set(o.GetLabels())) {
+		return false
+	}
+	if len(s.namespaces) == 0 {
+		return true
+	}
+	for _, ns := range s.namespaces {
+		if o.GetNamespace() == ns {
+			return true
+		}
+	}
+	return false
+}
+
+// handles - whether the controller handles the CR: it is selected, or it
+// is being deleted and still has the finalizer of the controller, which must
+// run even if the CR left the selection.
+func (s selection) handles(o metav1.Object) bool {
+	if s.selects(o) {
+		return true
+	}
+	if s.finalizer == "" || o.GetDeletionTimestamp() == nil {
+		return false
+	}
+	for _, f := range o.GetFinalizers() {
+		if f == s.finalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// predicate - filters out the events of CRs the controller doesn't handle.
+func (s selection) predicate() predicate.Predicate {
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return s.handles(e.Meta)
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return s.selects(e.Meta)
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return s.handles(e.MetaNew)
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return s.handles(e.Meta)
+		},
+	}
+}
@@ -18,7 +18,8 @@ import (
 	"encoding/json"
 	"time"
 
-	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/eventapi"
+	"github.com/water-hole/ansible-operator/pkg/ansible/runner"
+	"github.com/water-hole/ansible-operator/pkg/ansible/runner/eventapi"
 
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -38,6 +39,8 @@ type AnsibleResult struct {
 	Skipped          int                `json:"skipped"`
 	Failures         int                `json:"failures"`
 	TimeOfCompletion eventapi.EventTime `json:"completion"`
+	// Options are the ansible options the run used.
+	Options *runner.AnsibleOptions `json:"options,omitempty"`
 }
 
 // NewAnsibleResultFromStatusJobEvent - creates a Ansible status from job event.
@@ -80,6 +83,17 @@ func NewAnsibleResultFromMap(sm map[string]interface{}) *AnsibleResult {
 		s := v.(string)
 		a.TimeOfCompletion.UnmarshalJSON([]byte(s))
 	}
+	if v, ok := sm["options"]; ok {
+		a.Options = &runner.AnsibleOptions{}
+		b, err := json.Marshal(v)
+		if err == nil {
+			err = json.Unmarshal(b, a.Options)
+		}
+		if err != nil {
+			log.Info("unable to parse ansible options for status condition", "Options", v)
+			a.Options = nil
+		}
+	}
 	return a
 }
 
@@ -91,6 +105,8 @@ const (
 	RunningConditionType ConditionType = "Running"
 	// FailureConditionType - condition type of failure.
 	FailureConditionType ConditionType = "Failure"
+	// PausedConditionType - condition type of paused.
+	PausedConditionType ConditionType = "Paused"
 )
 
 // Condition - the condition for the ansible operator.
@@ -147,23 +163,133 @@ func createConditionFromMap(cm map[string]interface{}) Condition {
 	}
 }
 
+// DryRunChange - a change to an object that a task reported it would make
+// during a dry run.
+type DryRunChange struct {
+	Task       string `json:"task"`
+	Action     string `json:"action,omitempty"`
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name,omitempty"`
+}
+
+// NewDryRunChangeFromJobEvent - creates a dry run change from a job event of
+// a task that reported a change. Returns false for any other event.
+func NewDryRunChangeFromJobEvent(je eventapi.JobEvent) (*DryRunChange, bool) {
+	if je.Event != eventapi.EventRunnerOnOk {
+		return nil, false
+	}
+	res, ok := je.EventData["res"].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	if changed, _ := res["changed"].(bool); !changed {
+		return nil, false
+	}
+	c := &DryRunChange{}
+	c.Task, _ = je.EventData["task"].(string)
+	c.Action, _ = res["method"].(string)
+	if obj, ok := res["result"].(map[string]interface{}); ok {
+		c.APIVersion, _ = obj["apiVersion"].(string)
+		c.Kind, _ = obj["kind"].(string)
+		if m, ok := obj["metadata"].(map[string]interface{}); ok {
+			c.Namespace, _ = m["namespace"].(string)
+			c.Name, _ = m["name"].(string)
+		}
+	}
+	return c, true
+}
+
+// DryRunResult - the changes the last dry run reported it would make.
+type DryRunResult struct {
+	Changes          []DryRunChange `json:"changes"`
+	TimeOfCompletion metav1.Time    `json:"completion"`
+}
+
+// ScheduleStatus - the scheduled runs of a resource.
+type ScheduleStatus struct {
+	// Cron is the schedule the runs were computed for.
+	Cron string `json:"cron"`
+	// LastRun is the slot of the last scheduled run.
+	LastRun *metav1.Time `json:"lastRun,omitempty"`
+	// NextRun is the slot of the next scheduled run.
+	NextRun *metav1.Time `json:"nextRun,omitempty"`
+}
+
 // Status - The status for custom resources managed by the operator-sdk.
 type Status struct {
-	Conditions   []Condition            `json:"conditions"`
+	Conditions []Condition   `json:"conditions"`
+	DryRun     *DryRunResult `json:"dryRun,omitempty"`
+	// ObservedGeneration is the generation of the CR the last run
+	// reconciled.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// ConsecutiveFailures counts the last runs that failed in a row.
+	ConsecutiveFailures int `json:"consecutiveFailures,omitempty"`
+	// LastTrigger is the last value of the trigger annotation of the CR
+	// that caused a run.
+	LastTrigger  string                 `json:"lastTrigger,omitempty"`
+	Schedule     *ScheduleStatus        `json:"schedule,omitempty"`
 	CustomStatus map[string]interface{} `json:"-"`
 }
 
+// managedStatusKeys are the keys of the status managed by the operator, which
+// are not part of the custom status.
+var managedStatusKeys = map[string]bool{
+	"conditions":          true,
+	"dryRun":              true,
+	"observedGeneration":  true,
+	"consecutiveFailures": true,
+	"lastTrigger":         true,
+	"schedule":            true,
+}
+
 // CreateFromMap - create a status from the map
 func CreateFromMap(statusMap map[string]interface{}) Status {
 	customStatus := make(map[string]interface{})
 	for key, value := range statusMap {
-		if key != "conditions" {
+		if !managedStatusKeys[key] {
 			customStatus[key] = value
 		}
 	}
+	var dryRun *DryRunResult
+	if dr, ok := statusMap["dryRun"]; ok {
+		dryRun = &DryRunResult{}
+		b, err := json.Marshal(dr)
+		if err == nil {
+			err = json.Unmarshal(b, dryRun)
+		}
+		if err != nil {
+			log.Info("unable to parse dry run status, removing it", "DryRun", dr)
+			dryRun = nil
+		}
+	}
+	var schedule *ScheduleStatus
+	if ss, ok := statusMap["schedule"]; ok {
+		schedule = &ScheduleStatus{}
+		b, err := json.Marshal(ss)
+		if err == nil {
+			err = json.Unmarshal(b, schedule)
+		}
+		if err != nil {
+			log.Info("unable to parse schedule status, removing it", "Schedule", ss)
+			schedule = nil
+		}
+	}
+	observedGeneration, _ := statusMap["observedGeneration"].(int64)
+	consecutiveFailures, _ := statusMap["consecutiveFailures"].(int64)
+	lastTrigger, _ := statusMap["lastTrigger"].(string)
 	conditionsInterface, ok := statusMap["conditions"].([]interface{})
 	if !ok {
-		return Status{Conditions: []Condition{}, CustomStatus: customStatus}
+		return Status{
+			Conditions:          []Condition{},
+			DryRun:              dryRun,
+			ObservedGeneration:  observedGeneration,
+			ConsecutiveFailures: int(consecutiveFailures),
+			LastTrigger:         lastTrigger,
+			Schedule:            schedule,
+			CustomStatus:        customStatus,
+		}
 	}
 	conditions := []Condition{}
 	for _, ci := range conditionsInterface {
@@ -174,7 +300,15 @@ func CreateFromMap(statusMap map[string]interface{}) Status {
 		}
 		conditions = append(conditions, createConditionFromMap(cm))
 	}
-	return Status{Conditions: conditions, CustomStatus: customStatus}
+	return Status{
+		Conditions:          conditions,
+		DryRun:              dryRun,
+		ObservedGeneration:  observedGeneration,
+		ConsecutiveFailures: int(consecutiveFailures),
+		LastTrigger:         lastTrigger,
+		Schedule:            schedule,
+		CustomStatus:        customStatus,
+	}
 }
 
 // GetJSONMap - gets the map value for the status object.
@@ -28,6 +28,11 @@ const (
 	FailedReason = "Failed"
 	// UnknownFailedReason - Condition is unknown
 	UnknownFailedReason = "Unknown"
+	// BackoffLimitExceededReason - Condition is failed and the resource is no longer
+	// retried until its spec changes
+	BackoffLimitExceededReason = "BackoffLimitExceeded"
+	// PausedReason - Condition is paused by an annotation of the resource
+	PausedReason = "Paused"
 )
 
 const (
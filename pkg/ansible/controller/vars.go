@@ -0,0 +1,239 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/water-hole/ansible-operator/pkg/ansible/runner"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crthandler "sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// resolveVars reads the values of the extra variables of the watch from the
+// Secrets and ConfigMaps in the namespace of the CR.
+func (r *AnsibleOperatorReconciler) resolveVars(u *unstructured.Unstructured) (map[string]interface{}, map[string]interface{}, error) {
+	vars := map[string]interface{}{}
+	secretVars := map[string]interface{}{}
+	for _, v := range r.Runner.GetVars() {
+		value, found, err := r.readKey(u, v.KeyRef(), v.IsSecret())
+		if err != nil {
+			return nil, nil, fmt.Errorf("%v for var %v", err, v.Name)
+		}
+		if !found {
+			continue
+		}
+		if v.IsSecret() {
+			secretVars[v.Name] = value
+		} else {
+			vars[v.Name] = value
+		}
+	}
+	return vars, secretVars, nil
+}
+
+// resolveSecretEnv reads the environment variables of the watch that are
+// read from Secrets.
+func (r *AnsibleOperatorReconciler) resolveSecretEnv(u *unstructured.Unstructured) (map[string]string, error) {
+	env := map[string]string{}
+	for name, e := range r.Runner.GetEnv() {
+		if e.SecretKeyRef == nil {
+			continue
+		}
+		value, found, err := r.readKey(u, e.SecretKeyRef, true)
+		if err != nil {
+			return nil, fmt.Errorf("%v for env %v", err, name)
+		}
+		if found {
+			env[name] = value
+		}
+	}
+	return env, nil
+}
+
+// resolveVaultPassword reads the vault password of the watch, if it is read
+// from a Secret. The Secret is in the namespace of the operator, or the one
+// the watch names, which the cache may not hold, so it is read from the API.
+func (r *AnsibleOperatorReconciler) resolveVaultPassword() ([]byte, error) {
+	ref := r.Runner.GetVaultPasswordSecret()
+	if ref == nil {
+		return nil, nil
+	}
+	key := types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}
+	value, found, err := readObjectKey(r.APIReader, key, ref, true)
+	if err != nil {
+		return nil, fmt.Errorf("%v for the vault password", err)
+	}
+	if !found {
+		return nil, nil
+	}
+	return []byte(value), nil
+}
+
+// readKey reads the key of the Secret or ConfigMap selected by ref for the
+// CR. It reports whether an optional key was found.
+func (r *AnsibleOperatorReconciler) readKey(u *unstructured.Unstructured, ref *runner.KeyRef, secret bool) (string, bool, error) {
+	name := ref.ObjectName(u)
+	if name == "" {
+		if ref.Optional {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("no object name")
+	}
+	ns := r.namespaceFor(u)
+	if ns == "" {
+		return "", false, errors.New("no namespace, the watch of cluster scoped resources needs a defaultNamespace")
+	}
+	return readObjectKey(r.Client, types.NamespacedName{Namespace: ns, Name: name}, ref, secret)
+}
+
+// readObjectKey reads the key of ref from the Secret or ConfigMap key.
+func readObjectKey(reader client.Reader, key types.NamespacedName, ref *runner.KeyRef, secret bool) (string, bool, error) {
+	var value string
+	var found bool
+	var err error
+	if secret {
+		s := &corev1.Secret{}
+		err = reader.Get(context.TODO(), key, s)
+		if err == nil {
+			var b []byte
+			b, found = s.Data[ref.Key]
+			value = string(b)
+		}
+	} else {
+		configMap := &corev1.ConfigMap{}
+		err = reader.Get(context.TODO(), key, configMap)
+		if err == nil {
+			value, found = configMap.Data[ref.Key]
+		}
+	}
+	if err != nil && !apierrors.IsNotFound(err) {
+		return "", false, err
+	}
+	if !found && !ref.Optional {
+		return "", false, fmt.Errorf("key %v of %v not found", ref.Key, key)
+	}
+	return value, found, nil
+}
+
+// varsMapper enqueues the CRs of the GVK whose extra variables are read from
+// a changed Secret or ConfigMap.
+type varsMapper struct {
+	client client.Client
+	gvk    schema.GroupVersionKind
+	vars   []runner.VarSource
+	secret bool
+	// selection restricts the CRs that are enqueued.
+	selection selection
+	// clusterScoped CRs read their variables from namespace, the default
+	// namespace of the watch, rather than from their own namespace.
+	clusterScoped bool
+	namespace     string
+}
+
+// Map - implements crthandler.Mapper.
+func (m *varsMapper) Map(o crthandler.MapObject) []reconcile.Request {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(schema.GroupVersionKind{
+		Group:   m.gvk.Group,
+		Version: m.gvk.Version,
+		Kind:    m.gvk.Kind + "List",
+	})
+	opts := &client.ListOptions{
+		Namespace:     o.Meta.GetNamespace(),
+		LabelSelector: m.selection.selector,
+	}
+	if m.clusterScoped {
+		if o.Meta.GetNamespace() != m.namespace {
+			return nil
+		}
+		opts.Namespace = ""
+	}
+	err := m.client.List(context.TODO(), opts, list)
+	if err != nil {
+		log.Error(err, "Failed to list resources referencing object", "gvk", m.gvk.String(), "Namespace", o.Meta.GetNamespace(), "Name", o.Meta.GetName())
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for i := range list.Items {
+		u := &list.Items[i]
+		if !m.selection.selects(u) {
+			continue
+		}
+		for _, v := range m.vars {
+			if v.IsSecret() == m.secret && v.KeyRef().ObjectName(u) == o.Meta.GetName() {
+				requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+					Namespace: u.GetNamespace(),
+					Name:      u.GetName(),
+				}})
+				break
+			}
+		}
+	}
+	return requests
+}
+
+// vaultMapper enqueues the CRs of the GVK when the Secret holding the vault
+// password of the watch changes.
+type vaultMapper struct {
+	client client.Client
+	gvk    schema.GroupVersionKind
+	// selection restricts the CRs that are enqueued.
+	selection selection
+	// namespaces are the namespaces the CRs of the controller are watched
+	// in, an empty namespace is every namespace.
+	namespaces []string
+}
+
+// Map - implements crthandler.Mapper.
+func (m *vaultMapper) Map(o crthandler.MapObject) []reconcile.Request {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(schema.GroupVersionKind{
+		Group:   m.gvk.Group,
+		Version: m.gvk.Version,
+		Kind:    m.gvk.Kind + "List",
+	})
+	requests := []reconcile.Request{}
+	for _, ns := range m.namespaces {
+		opts := &client.ListOptions{
+			Namespace:     ns,
+			LabelSelector: m.selection.selector,
+		}
+		err := m.client.List(context.TODO(), opts, list)
+		if err != nil {
+			log.Error(err, "Failed to list resources using the vault password", "gvk", m.gvk.String(), "Namespace", o.Meta.GetNamespace(), "Name", o.Meta.GetName())
+			continue
+		}
+		for i := range list.Items {
+			u := &list.Items[i]
+			if m.selection.selects(u) {
+				requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+					Namespace: u.GetNamespace(),
+					Name:      u.GetName(),
+				}})
+			}
+		}
+	}
+	return requests
+}
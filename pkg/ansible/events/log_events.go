@@ -16,8 +16,12 @@ package events
 
 import (
 	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
 
-	"github.com/operator-framework/operator-sdk/pkg/ansible/runner/eventapi"
+	"github.com/water-hole/ansible-operator/pkg/ansible/runner/eventapi"
 
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
@@ -35,8 +39,37 @@ const (
 
 	// Nothing -  this will log nothing.
 	Nothing
+
+	// Stdout - print the human readable output of ansible instead of
+	// structured logs.
+	Stdout
 )
 
+var logLevelNames = map[LogLevel]string{
+	Tasks:      "tasks",
+	Everything: "everything",
+	Nothing:    "nothing",
+	Stdout:     "stdout",
+}
+
+// String - the name of the log level.
+func (l LogLevel) String() string {
+	if name, ok := logLevelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("LogLevel(%d)", int(l))
+}
+
+// ParseLogLevel - returns the log level with the given name.
+func ParseLogLevel(name string) (LogLevel, error) {
+	for l, n := range logLevelNames {
+		if strings.EqualFold(n, name) {
+			return l, nil
+		}
+	}
+	return Tasks, fmt.Errorf("unknown log level %q, must be one of tasks, everything, nothing or stdout", name)
+}
+
 // EventHandler - knows how to handle job events.
 type EventHandler interface {
 	Handle(string, *unstructured.Unstructured, eventapi.JobEvent)
@@ -46,10 +79,22 @@ type loggingEventHandler struct {
 	LogLevel LogLevel
 }
 
+// stdoutMutex keeps the output of concurrent events from interleaving.
+var stdoutMutex sync.Mutex
+
 func (l loggingEventHandler) Handle(ident string, u *unstructured.Unstructured, e eventapi.JobEvent) {
 	if l.LogLevel == Nothing {
 		return
 	}
+	if l.LogLevel == Stdout {
+		if e.StdOut == "" {
+			return
+		}
+		stdoutMutex.Lock()
+		defer stdoutMutex.Unlock()
+		fmt.Fprintln(os.Stdout, e.StdOut)
+		return
+	}
 
 	logger := logf.Log.WithName("logging_event_handler").WithValues(
 		"name", u.GetName(),
@@ -0,0 +1,105 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/water-hole/ansible-operator/pkg/ansible/controller"
+	"github.com/water-hole/ansible-operator/pkg/ansible/events"
+	"github.com/water-hole/ansible-operator/pkg/ansible/proxy/kubeconfig"
+	"github.com/water-hole/ansible-operator/pkg/ansible/runner"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+	"sigs.k8s.io/controller-runtime/pkg/runtime/signals"
+)
+
+// Options - options for running the ansible operator.
+type Options struct {
+	// Watches are the runners of the watched kinds, see
+	// runner.NewFromWatches.
+	Watches map[schema.GroupVersionKind]runner.Runner
+	// ReconcilePeriod is the default reconcile period for controllers.
+	ReconcilePeriod time.Duration
+	// DryRun runs every reconciliation as a dry run.
+	DryRun bool
+	// LoggingLevel is the default level at which ansible events are logged.
+	LoggingLevel events.LogLevel
+	// Runs registers the runs in progress with the proxy, which must share
+	// it.
+	Runs *kubeconfig.Runs
+	// Caches are the caches of the namespaces the operator watches, added
+	// to the manager given to Run.
+	Caches *controller.Caches
+}
+
+// Run - A blocking function which starts a controller-runtime manager
+// It starts an Operator by adding a controller for each of the watches to the
+// manager, and finally running the manager.
+func Run(done chan error, mgr manager.Manager, opts Options) {
+	rand.Seed(time.Now().Unix())
+	c := signals.SetupSignalHandler()
+
+	for gvk, runner := range opts.Watches {
+		o := controller.Options{
+			GVK:              gvk,
+			Runner:           runner,
+			ReconcilePeriod:  opts.ReconcilePeriod,
+			ManageStatus:     runner.GetManageStatus(),
+			Policy:           runner.GetPolicy(),
+			DryRun:           opts.DryRun,
+			LoggingLevel:     opts.LoggingLevel,
+			Selector:         runner.GetSelector(),
+			Namespaces:       runner.GetNamespaces(),
+			Backoff:          runner.GetBackoff(),
+			Schedule:         runner.GetSchedule(),
+			ClusterScoped:    clusterScoped(mgr, gvk, runner),
+			DefaultNamespace: runner.GetDefaultNamespace(),
+			ReadOnly:         runner.GetReadOnly(),
+			Runs:             opts.Runs,
+			Caches:           opts.Caches,
+		}
+		d, ok := runner.GetReconcilePeriod()
+		if ok {
+			o.ReconcilePeriod = d
+		}
+		if l, ok := runner.GetLogEvents(); ok {
+			o.LoggingLevel = l
+		}
+		if sa, ok := runner.GetImpersonation(); ok {
+			o.ImpersonateServiceAccount = sa
+		}
+		controller.Add(mgr, o)
+	}
+	done <- mgr.Start(c)
+}
+
+// clusterScoped - whether the resources of the watch are cluster-scoped, as
+// the watch says or else as the REST mapping of the kind says.
+func clusterScoped(mgr manager.Manager, gvk schema.GroupVersionKind, r runner.Runner) bool {
+	if cs, ok := r.GetClusterScoped(); ok {
+		return cs
+	}
+	mapping, err := mgr.GetRESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		logf.Log.WithName("manager").Info("Unable to discover the scope of the kind, assuming it is namespaced; set clusterScoped in the watch otherwise", "GVK", gvk.String(), "Error", err.Error())
+		return false
+	}
+	return mapping.Scope.Name() == meta.RESTScopeNameRoot
+}
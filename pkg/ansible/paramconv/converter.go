@@ -0,0 +1,136 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package paramconv
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Converter converts keys between camelCase and snake_case so that the keys
+// it knows, the properties of a CRD's schema, always round-trip:
+// ToCamel(ToSnake(key)) is key even when the acronyms don't capture how the
+// key is written, like "podCIDR" or "Id". The runner converts the spec of a
+// CR to parameters with it, and the proxy converts the status a run writes
+// back to camelCase.
+type Converter struct {
+	toSnake map[string]string
+	toCamel map[string]string
+}
+
+// NewConverter returns a Converter for the given camelCase keys. It fails if
+// two of them convert to the same snake_case key, since those can't
+// round-trip.
+func NewConverter(keys []string) (*Converter, error) {
+	c := &Converter{
+		toSnake: map[string]string{},
+		toCamel: map[string]string{},
+	}
+	sorted := append([]string{}, keys...)
+	sort.Strings(sorted)
+	collisions := []string{}
+	for _, key := range sorted {
+		if _, ok := c.toSnake[key]; ok || key == "" {
+			continue
+		}
+		snake := ToSnake(key)
+		if other, ok := c.toCamel[snake]; ok {
+			collisions = append(collisions, fmt.Sprintf("%v and %v as %v", other, key, snake))
+			continue
+		}
+		c.toSnake[key] = snake
+		c.toCamel[snake] = key
+	}
+	if len(collisions) != 0 {
+		return nil, fmt.Errorf("keys collide as snake_case: %v", strings.Join(collisions, ", "))
+	}
+	return c, nil
+}
+
+// NewConverterForSchema returns a Converter for the properties of an
+// openAPIV3Schema and of the schemas nested in it.
+func NewConverterForSchema(schema map[string]interface{}) (*Converter, error) {
+	return NewConverter(SchemaKeys(schema))
+}
+
+// SchemaKeys returns the names of the properties of an openAPIV3Schema and of
+// the schemas nested in it, through items and additionalProperties as well.
+func SchemaKeys(schema map[string]interface{}) []string {
+	keys := []string{}
+	var collect func(s interface{})
+	collect = func(s interface{}) {
+		switch s := s.(type) {
+		case map[string]interface{}:
+			if properties, ok := s["properties"].(map[string]interface{}); ok {
+				for key, property := range properties {
+					keys = append(keys, key)
+					collect(property)
+				}
+			}
+			collect(s["items"])
+			collect(s["additionalProperties"])
+		case []interface{}:
+			// items may be a list of schemas
+			for _, item := range s {
+				collect(item)
+			}
+		}
+	}
+	collect(schema)
+	return keys
+}
+
+// ToSnake converts a camelCase key to snake_case.
+func (c *Converter) ToSnake(s string) string {
+	if snake, ok := c.toSnake[s]; ok {
+		return snake
+	}
+	return ToSnake(s)
+}
+
+// ToCamel converts a snake_case key to camelCase, restoring the form of the
+// known keys. Other keys are converted like ToCamel does if they contain a
+// "_", and are otherwise left as they are, since they may already be
+// camelCase.
+func (c *Converter) ToCamel(s string) string {
+	if camel, ok := c.toCamel[s]; ok {
+		return camel
+	}
+	if !strings.Contains(s, "_") {
+		return s
+	}
+	return ToCamel(s)
+}
+
+// MapToSnake converts the keys of in, and of the maps nested in it, to
+// snake_case.
+func (c *Converter) MapToSnake(in map[string]interface{}) map[string]interface{} {
+	return convertMapKeys(c.ToSnake, in)
+}
+
+// MapToCamel converts the keys of in, and of the maps nested in it, to
+// camelCase.
+func (c *Converter) MapToCamel(in map[string]interface{}) map[string]interface{} {
+	return convertMapKeys(c.ToCamel, in)
+}
+
+// SnakeCollisions returns the keys of in that MapToSnake converts to the same
+// key, like the SnakeCollisions function.
+func (c *Converter) SnakeCollisions(in map[string]interface{}) map[string][]string {
+	found := map[string][]string{}
+	collisions(c.ToSnake, "", in, found)
+	return found
+}
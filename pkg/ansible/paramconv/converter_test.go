@@ -0,0 +1,229 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package paramconv
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// acronymKeys are keys written with the acronyms of the operator
+// configuration, that round-trip without a Converter once the acronyms are
+// set.
+var acronymKeys = []string{
+	"healthURL", "clusterIP", "podIP", "serverTLS", "upstreamDNS", "userID",
+	"externalIP", "proxyHTTPPort",
+}
+
+// schema is the openAPIV3Schema of a CRD whose keys can't all round-trip
+// with any acronym table.
+var schema = map[string]interface{}{
+	"properties": map[string]interface{}{
+		"spec": map[string]interface{}{
+			"properties": map[string]interface{}{
+				"size":     map[string]interface{}{"type": "integer"},
+				"podCIDR":  map[string]interface{}{"type": "string"},
+				"Id":       map[string]interface{}{"type": "string"},
+				"tlsKey":   map[string]interface{}{"type": "string"},
+				"port8080": map[string]interface{}{"type": "boolean"},
+				"servers": map[string]interface{}{
+					"type": "array",
+					"items": map[string]interface{}{
+						"properties": map[string]interface{}{
+							"clusterIP": map[string]interface{}{"type": "string"},
+							"healthURL": map[string]interface{}{"type": "string"},
+						},
+					},
+				},
+				"labels": map[string]interface{}{
+					"type": "object",
+					"additionalProperties": map[string]interface{}{
+						"properties": map[string]interface{}{
+							"x509Cert": map[string]interface{}{"type": "string"},
+						},
+					},
+				},
+			},
+		},
+		"status": map[string]interface{}{
+			"properties": map[string]interface{}{
+				"readyReplicas": map[string]interface{}{"type": "integer"},
+				"serviceCIDR":   map[string]interface{}{"type": "string"},
+				"v1alpha1":      map[string]interface{}{"type": "object"},
+			},
+		},
+	},
+}
+
+// schemaKeys are the keys of schema with their snake_case form, written out
+// rather than computed, whatever the acronyms.
+var schemaKeys = map[string]string{
+	"spec":          "spec",
+	"size":          "size",
+	"podCIDR":       "pod_cidr",
+	"Id":            "_id",
+	"tlsKey":        "tls_key",
+	"port8080":      "port_8080",
+	"servers":       "servers",
+	"clusterIP":     "cluster_ip",
+	"healthURL":     "health_url",
+	"labels":        "labels",
+	"x509Cert":      "x_509_cert",
+	"status":        "status",
+	"readyReplicas": "ready_replicas",
+	"serviceCIDR":   "service_cidr",
+	"v1alpha1":      "v_1_alpha_1",
+}
+
+var acronymTables = [][]string{DefaultAcronyms, {"HTTP", "URL", "IP", "TLS", "DNS", "ID"}, {}}
+
+func withAcronyms(t *testing.T, acronyms []string) {
+	SetAcronyms(acronyms)
+	t.Cleanup(func() { SetAcronyms(DefaultAcronyms) })
+}
+
+func TestAcronymsRoundTrip(t *testing.T) {
+	withAcronyms(t, []string{"HTTP", "URL", "IP", "TLS", "DNS", "ID"})
+	for _, key := range acronymKeys {
+		if got := ToCamel(ToSnake(key)); got != key {
+			t.Errorf("ToCamel(ToSnake(%q)) = %q via %q", key, got, ToSnake(key))
+		}
+	}
+}
+
+func TestSetAcronyms(t *testing.T) {
+	if got := ToCamel("tls_key"); got != "tlsKey" {
+		t.Errorf("ToCamel(%q) = %q with the default acronyms", "tls_key", got)
+	}
+	withAcronyms(t, []string{" tls ", "", "Dns"})
+	for snake, camel := range map[string]string{
+		"tls_key":      "TLSKey",
+		"server_tls":   "serverTLS",
+		"upstream_dns": "upstreamDNS",
+		"health_url":   "healthUrl",
+	} {
+		if got := ToCamel(snake); got != camel {
+			t.Errorf("ToCamel(%q) = %q, want %q", snake, got, camel)
+		}
+	}
+}
+
+func TestSchemaKeys(t *testing.T) {
+	keys := SchemaKeys(schema)
+	sort.Strings(keys)
+	want := []string{}
+	for key := range schemaKeys {
+		want = append(want, key)
+	}
+	sort.Strings(want)
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("SchemaKeys = %v, want %v", keys, want)
+	}
+}
+
+func TestConverterRoundTrip(t *testing.T) {
+	for _, acronyms := range acronymTables {
+		withAcronyms(t, acronyms)
+		c, err := NewConverterForSchema(schema)
+		if err != nil {
+			t.Fatalf("NewConverterForSchema with acronyms %v: %v", acronyms, err)
+		}
+		for camel, snake := range schemaKeys {
+			if got := c.ToSnake(camel); got != snake {
+				t.Errorf("with acronyms %v, ToSnake(%q) = %q, want %q", acronyms, camel, got, snake)
+			}
+			if got := c.ToCamel(snake); got != camel {
+				t.Errorf("with acronyms %v, ToCamel(%q) = %q, want %q", acronyms, snake, got, camel)
+			}
+		}
+	}
+}
+
+func TestToSnakeIgnoresAcronyms(t *testing.T) {
+	for _, acronyms := range acronymTables {
+		withAcronyms(t, acronyms)
+		for key, snake := range map[string]string{
+			"TLSKey":    "_tls_key",
+			"IDNumber":  "_id_number",
+			"URLPath":   "url_path",
+			"serverTLS": "server_tls",
+		} {
+			if got := ToSnake(key); got != snake {
+				t.Errorf("with acronyms %v, ToSnake(%q) = %q, want %q", acronyms, key, got, snake)
+			}
+		}
+	}
+}
+
+func TestConverterUnknownKeys(t *testing.T) {
+	withAcronyms(t, []string{"TLS"})
+	c, err := NewConverterForSchema(schema)
+	if err != nil {
+		t.Fatalf("NewConverterForSchema: %v", err)
+	}
+	for snake, camel := range map[string]string{
+		"tls_cert":           "TLSCert",
+		"ready_nodes":        "readyNodes",
+		"lastTransitionTime": "lastTransitionTime",
+		"v2beta1":            "v2beta1",
+		"message":            "message",
+	} {
+		if got := c.ToCamel(snake); got != camel {
+			t.Errorf("ToCamel(%q) = %q, want %q", snake, got, camel)
+		}
+	}
+}
+
+func TestConverterCollisions(t *testing.T) {
+	for _, keys := range [][]string{{"tlsKey", "tls_key"}, {"ID", "Id"}} {
+		if _, err := NewConverter(keys); err == nil {
+			t.Errorf("NewConverter accepted %v, which collide as snake_case", keys)
+		}
+	}
+	if _, err := NewConverter([]string{"tlsKey", "tlsKey", ""}); err != nil {
+		t.Errorf("NewConverter rejected a repeated key: %v", err)
+	}
+}
+
+func TestConverterMaps(t *testing.T) {
+	c, err := NewConverterForSchema(schema)
+	if err != nil {
+		t.Fatalf("NewConverterForSchema: %v", err)
+	}
+	spec := map[string]interface{}{
+		"podCIDR": "10.0.0.0/16",
+		"servers": []interface{}{
+			map[string]interface{}{"clusterIP": "10.0.0.1", "Id": 1},
+		},
+	}
+	snake := c.MapToSnake(spec)
+	want := map[string]interface{}{
+		"pod_cidr": "10.0.0.0/16",
+		"servers": []interface{}{
+			map[string]interface{}{"cluster_ip": "10.0.0.1", "_id": 1},
+		},
+	}
+	if !reflect.DeepEqual(snake, want) {
+		t.Errorf("MapToSnake = %v, want %v", snake, want)
+	}
+	if camel := c.MapToCamel(snake); !reflect.DeepEqual(camel, spec) {
+		t.Errorf("MapToCamel(MapToSnake(spec)) = %v, want %v", camel, spec)
+	}
+	found := c.SnakeCollisions(map[string]interface{}{"podCIDR": 1, "pod_cidr": 2})
+	if !reflect.DeepEqual(found, map[string][]string{"pod_cidr": {"podCIDR", "pod_cidr"}}) {
+		t.Errorf("SnakeCollisions = %v", found)
+	}
+}
@@ -18,6 +18,7 @@ package paramconv
 
 import (
 	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -29,8 +30,36 @@ var (
 		"url":  "URL",
 		"ip":   "IP",
 	}
+	// snakeWords are the acronyms ToSnake doesn't prefix with "_" when a key
+	// starts with them. They stay the default acronyms whatever SetAcronyms
+	// sets, so that the acronyms never rename the parameters of a CR.
+	snakeWords = map[string]bool{
+		"http": true,
+		"url":  true,
+		"ip":   true,
+	}
 )
 
+// DefaultAcronyms are the words written in upper case in camelCase unless
+// SetAcronyms replaces them.
+var DefaultAcronyms = []string{"HTTP", "URL", "IP"}
+
+// SetAcronyms replaces the words that ToCamel writes in upper case, e.g. with
+// "TLS" ToCamel("tls_key") is "TLSKey" rather than "TlsKey". It must be called
+// before any conversion, typically from the operator's configuration. ToSnake
+// isn't affected.
+func SetAcronyms(acronyms []string) {
+	m := make(map[string]string, len(acronyms))
+	for _, a := range acronyms {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			continue
+		}
+		m[strings.ToLower(a)] = strings.ToUpper(a)
+	}
+	wordMapping = m
+}
+
 func addWordBoundariesToNumbers(s string) string {
 	b := []byte(s)
 	b = numberSequence.ReplaceAll(b, numberReplacement)
@@ -118,18 +147,29 @@ func ToSnake(s string) string {
 	}
 	bits = append(bits, strings.ToLower(n))
 	joined := strings.Join(bits, "_")
-	if _, ok := wordMapping[bits[0]]; !ok {
+	if !snakeWords[bits[0]] {
 		return prefix + joined
 	}
 	return joined
 }
 
+// sortedKeys returns the keys of in sorted, so that when several keys convert
+// to the same key the same one always wins.
+func sortedKeys(in map[string]interface{}) []string {
+	keys := make([]string, 0, len(in))
+	for key := range in {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func convertParameter(fn func(string) string, v interface{}) interface{} {
 	switch v := v.(type) {
 	case map[string]interface{}:
 		ret := map[string]interface{}{}
-		for key, val := range v {
-			ret[fn(key)] = convertParameter(fn, val)
+		for _, key := range sortedKeys(v) {
+			ret[fn(key)] = convertParameter(fn, v[key])
 		}
 		return ret
 	case []interface{}:
@@ -149,12 +189,45 @@ func convertArray(fn func(string) string, in []interface{}) []interface{} {
 
 func convertMapKeys(fn func(string) string, in map[string]interface{}) map[string]interface{} {
 	converted := map[string]interface{}{}
-	for key, val := range in {
-		converted[fn(key)] = convertParameter(fn, val)
+	for _, key := range sortedKeys(in) {
+		converted[fn(key)] = convertParameter(fn, in[key])
 	}
 	return converted
 }
 
+// collisions appends the keys of in, and of the maps nested in it, that
+// convert to the same key with fn, indexed by the dotted path of the
+// converted key.
+func collisions(fn func(string) string, path string, v interface{}, found map[string][]string) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		byKey := map[string][]string{}
+		for _, key := range sortedKeys(v) {
+			converted := fn(key)
+			byKey[converted] = append(byKey[converted], key)
+			collisions(fn, path+converted+".", v[key], found)
+		}
+		for converted, keys := range byKey {
+			if len(keys) > 1 {
+				found[path+converted] = keys
+			}
+		}
+	case []interface{}:
+		for _, val := range v {
+			collisions(fn, path, val, found)
+		}
+	}
+}
+
+// SnakeCollisions returns the keys of in that MapToSnake converts to the same
+// key, indexed by the dotted path of the snake_case key. Only the value of
+// the last of the keys in sorted order is kept by MapToSnake.
+func SnakeCollisions(in map[string]interface{}) map[string][]string {
+	found := map[string][]string{}
+	collisions(ToSnake, "", in, found)
+	return found
+}
+
 func MapToSnake(in map[string]interface{}) map[string]interface{} {
 	return convertMapKeys(ToSnake, in)
 }
@@ -0,0 +1,189 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	k8sRequest "github.com/water-hole/ansible-operator/pkg/ansible/proxy/requestfactory"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// redactedValue replaces sensitive values in captured request bodies.
+const redactedValue = "REDACTED"
+
+// AuditEntry - a record of a single mutating API request made through the
+// proxy. Entries are written as JSON lines.
+type AuditEntry struct {
+	Timestamp   time.Time       `json:"timestamp"`
+	Job         string          `json:"job,omitempty"`
+	Owner       *AuditOwner     `json:"owner,omitempty"`
+	Method      string          `json:"method"`
+	Path        string          `json:"path"`
+	Verb        string          `json:"verb,omitempty"`
+	Group       string          `json:"group,omitempty"`
+	Version     string          `json:"version,omitempty"`
+	Resource    string          `json:"resource,omitempty"`
+	Subresource string          `json:"subresource,omitempty"`
+	Namespace   string          `json:"namespace,omitempty"`
+	Name        string          `json:"name,omitempty"`
+	Code        int             `json:"code"`
+	Latency     string          `json:"latency"`
+	RequestBody json.RawMessage `json:"requestBody,omitempty"`
+}
+
+// AuditOwner - the CR that owns the run that made a request.
+type AuditOwner struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	UID        string `json:"uid,omitempty"`
+}
+
+// auditResponseWriter records the status code written by the handlers it
+// wraps.
+type auditResponseWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (a *auditResponseWriter) WriteHeader(code int) {
+	a.code = code
+	a.ResponseWriter.WriteHeader(code)
+}
+
+func (a *auditResponseWriter) Flush() {
+	if f, ok := a.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack is needed for requests that upgrade the connection.
+func (a *auditResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := a.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	a.code = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// AuditHandler will handle proxied requests and write an AuditEntry to out
+// for every request that is not a read. When captureBodies is set the request
+// body is recorded as well, with the data of Secrets redacted.
+func AuditHandler(h http.Handler, out io.Writer, captureBodies bool) http.Handler {
+	mutex := sync.Mutex{}
+	encoder := json.NewEncoder(out)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodGet || req.Method == http.MethodHead {
+			h.ServeHTTP(w, req)
+			return
+		}
+		entry := AuditEntry{
+			Timestamp: time.Now().UTC(),
+			Method:    req.Method,
+			Path:      req.URL.Path,
+		}
+		rf := k8sRequest.RequestInfoFactory{APIPrefixes: sets.NewString("api", "apis"), GrouplessAPIPrefixes: sets.NewString("api")}
+		r, err := rf.NewRequestInfo(req)
+		if err == nil && r.IsResourceRequest {
+			entry.Verb = r.Verb
+			entry.Group = r.APIGroup
+			entry.Version = r.APIVersion
+			entry.Resource = r.Resource
+			entry.Subresource = r.Subresource
+			entry.Namespace = r.Namespace
+			entry.Name = r.Name
+		}
+		// Only the RunInfo registered by the operator is trusted, the
+		// credentials of the request are controlled by ansible.
+		if info, ok := runInfoFromRequest(req); ok {
+			entry.Job = info.Job
+			entry.Owner = &AuditOwner{
+				APIVersion: info.Owner.APIVersion,
+				Kind:       info.Owner.Kind,
+				Namespace:  info.OwnerNamespace,
+				Name:       info.Owner.Name,
+				UID:        string(info.Owner.UID),
+			}
+		}
+		if captureBodies && req.Body != nil {
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				m := "could not read request body"
+				log.Error(err, m)
+				http.Error(w, m, http.StatusInternalServerError)
+				return
+			}
+			req.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+			entry.RequestBody = auditBody(entry.Group, entry.Resource, body)
+		}
+
+		aw := &auditResponseWriter{ResponseWriter: w, code: http.StatusOK}
+		start := time.Now()
+		h.ServeHTTP(aw, req)
+		entry.Latency = time.Since(start).String()
+		entry.Code = aw.code
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		if err := encoder.Encode(entry); err != nil {
+			log.Error(err, "failed to write audit entry")
+		}
+	})
+}
+
+// auditBody returns the request body as it should be recorded. The data of
+// Secrets is redacted and bodies that are not JSON are recorded as a string.
+func auditBody(group, resource string, body []byte) json.RawMessage {
+	if len(body) == 0 {
+		return nil
+	}
+	isSecret := group == "" && resource == "secrets"
+	obj := map[string]interface{}{}
+	if err := json.Unmarshal(body, &obj); err != nil {
+		if isSecret {
+			body = []byte(redactedValue)
+		}
+		s, _ := json.Marshal(string(body))
+		return s
+	}
+	if isSecret {
+		for _, field := range []string{"data", "stringData"} {
+			data, ok := obj[field].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for k := range data {
+				data[k] = redactedValue
+			}
+		}
+	}
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return nil
+	}
+	return b
+}
@@ -23,13 +23,16 @@ import (
 	"net/url"
 	"os"
 
+	"github.com/water-hole/ansible-operator/pkg/ansible/paramconv"
+	"github.com/water-hole/ansible-operator/pkg/ansible/proxy/policy"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // kubectl, as of 1.10.5, only does basic auth if the username is present in
 // the URL. The python client used by ansible, as of 6.0.0, only does basic
 // auth if the username and password are provided under the "user" key within
-// "users".
+// "users". The username carries the owner reference and the password is the
+// token of the run, registered in Runs.
 const kubeConfigTemplate = `---
 apiVersion: v1
 kind: Config
@@ -42,25 +45,61 @@ contexts:
 - context:
     cluster: proxy-server
     user: admin/proxy-server
-  name: {{.Namespace}}/proxy-server
-current-context: {{.Namespace}}/proxy-server
+{{- if .Namespace}}
+    namespace: {{.Namespace}}
+{{- end}}
+  name: {{.Context}}
+current-context: {{.Context}}
 preferences: {}
 users:
 - name: admin/proxy-server
   user:
     username: {{.Username}}
-    password: unused
+    password: {{.Password}}
 `
 
+// RunInfo - information about a single run of ansible that the proxy needs
+// to handle its requests.
+type RunInfo struct {
+	// Job is the ident of the run.
+	Job string
+	// ImpersonateUser is the user the proxy will impersonate for every
+	// request of the run. Empty means the operator's own credentials are used.
+	ImpersonateUser string
+	// ImpersonateGroups are the groups the proxy will impersonate along with
+	// ImpersonateUser.
+	ImpersonateGroups []string
+	// Namespace is the namespace the run works in: the namespace of the CR
+	// that owns the run, or the default namespace of a cluster-scoped CR.
+	Namespace string
+	// Owner is the reference to the CR that owns the run.
+	Owner metav1.OwnerReference
+	// OwnerNamespace is the namespace of the CR that owns the run, empty
+	// when it is cluster-scoped.
+	OwnerNamespace string
+	// Policy restricts the writes the run may make. Nil means unrestricted.
+	Policy *policy.Policy
+	// DryRun makes the API server only validate, and not persist, the
+	// writes of the run.
+	DryRun bool
+	// Converter converts the keys of the status the run writes for its
+	// owner to camelCase. Nil leaves them as they are.
+	Converter *paramconv.Converter
+}
+
 // values holds the data used to render the template
 type values struct {
 	Username  string
+	Password  string
 	ProxyURL  string
 	Namespace string
+	Context   string
 }
 
-// Create renders a kubeconfig template and writes it to disk
-func Create(ownerRef metav1.OwnerReference, proxyURL string, namespace string) (*os.File, error) {
+// Create renders a kubeconfig template and writes it to disk. The namespace
+// is the default namespace of the context, none when empty, and the token is
+// the one Runs registered for the run.
+func Create(ownerRef metav1.OwnerReference, proxyURL string, namespace string, token string) (*os.File, error) {
 	parsedURL, err := url.Parse(proxyURL)
 	if err != nil {
 		return nil, err
@@ -70,11 +109,18 @@ func Create(ownerRef metav1.OwnerReference, proxyURL string, namespace string) (
 		return nil, err
 	}
 	username := base64.URLEncoding.EncodeToString([]byte(ownerRefJSON))
-	parsedURL.User = url.User(username)
+	password := token
+	parsedURL.User = url.UserPassword(username, password)
+	serverURL := parsedURL.String()
 	v := values{
 		Username:  username,
-		ProxyURL:  parsedURL.String(),
+		Password:  password,
+		ProxyURL:  serverURL,
 		Namespace: namespace,
+		Context:   "proxy-server",
+	}
+	if namespace != "" {
+		v.Context = namespace + "/proxy-server"
 	}
 
 	var parsed bytes.Buffer
@@ -0,0 +1,68 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubeconfig
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+)
+
+// tokenBytes is the number of random bytes of a run token.
+const tokenBytes = 32
+
+// Runs - the RunInfo of the runs in progress, by the token each run uses as
+// the password of its kubeconfig. Ansible controls the requests of a run, so
+// the proxy only trusts the RunInfo registered here by the operator, and
+// never anything decoded from the credentials of a request.
+type Runs struct {
+	mutex sync.RWMutex
+	runs  map[string]RunInfo
+}
+
+// NewRuns - returns an empty registry of runs.
+func NewRuns() *Runs {
+	return &Runs{runs: map[string]RunInfo{}}
+}
+
+// Register - records the RunInfo of a new run and returns the random token
+// the run authenticates to the proxy with.
+func (r *Runs) Register(info RunInfo) (string, error) {
+	b := make([]byte, tokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.runs[token] = info
+	return token, nil
+}
+
+// Unregister - forgets the run of the token once it is over, so that the
+// token is no longer accepted.
+func (r *Runs) Unregister(token string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.runs, token)
+}
+
+// Get - returns the RunInfo of the run of the token, if it is in progress.
+func (r *Runs) Get(token string) (RunInfo, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	info, ok := r.runs[token]
+	return info, ok
+}
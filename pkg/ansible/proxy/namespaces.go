@@ -0,0 +1,73 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// MultiNamespaceReader - reads objects from the cache of their namespace,
+// when an operator watches several namespaces with a cache for each. Objects
+// of other namespaces and cluster-scoped objects are reported as not found,
+// so the proxy passes their requests to the API server instead of starting
+// informers the operator may not be allowed to run.
+type MultiNamespaceReader map[string]client.Reader
+
+// Get - implements client.Reader.
+func (m MultiNamespaceReader) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	r, ok := m[key.Namespace]
+	if !ok {
+		return apierrors.NewNotFound(schema.GroupResource{}, key.Name)
+	}
+	return r.Get(ctx, key, obj)
+}
+
+// List - implements client.Reader. Listing without a namespace combines the
+// objects of every namespace.
+func (m MultiNamespaceReader) List(ctx context.Context, opts *client.ListOptions, list runtime.Object) error {
+	if opts != nil && opts.Namespace != "" {
+		r, ok := m[opts.Namespace]
+		if !ok {
+			return fmt.Errorf("namespace %v is not cached", opts.Namespace)
+		}
+		return r.List(ctx, opts, list)
+	}
+	items := []runtime.Object{}
+	for ns, r := range m {
+		nsOpts := &client.ListOptions{Namespace: ns}
+		if opts != nil {
+			o := *opts
+			o.Namespace = ns
+			nsOpts = &o
+		}
+		nsList := list.DeepCopyObject()
+		if err := r.List(ctx, nsOpts, nsList); err != nil {
+			return err
+		}
+		nsItems, err := meta.ExtractList(nsList)
+		if err != nil {
+			return err
+		}
+		items = append(items, nsItems...)
+	}
+	return meta.SetList(list, items)
+}
@@ -0,0 +1,126 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"fmt"
+
+	k8sRequest "github.com/water-hole/ansible-operator/pkg/ansible/proxy/requestfactory"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// Wildcard matches any namespace, group, version, resource or verb.
+const Wildcard = "*"
+
+// readVerbs are never restricted by a policy.
+var readVerbs = sets.NewString("get", "list", "watch")
+
+// Policy - restricts the writes a run of ansible may make through the proxy.
+// Writes are only allowed in the namespace of the CR that owns the run unless
+// the policy explicitly allows more.
+type Policy struct {
+	// AllowedNamespaces are namespaces, in addition to the namespace of the
+	// CR, that may be written to. "*" allows every namespace.
+	AllowedNamespaces []string `yaml:"allowedNamespaces" json:"allowedNamespaces,omitempty"`
+	// AllowClusterScoped allows writes to cluster scoped resources.
+	AllowClusterScoped bool `yaml:"allowClusterScoped" json:"allowClusterScoped,omitempty"`
+	// AllowedResources are the only resources that may be written to. An
+	// empty list allows every resource.
+	AllowedResources []Resource `yaml:"allowedResources" json:"allowedResources,omitempty"`
+	// DeniedVerbs are verbs that are refused for the matching resources, even
+	// if they would otherwise be allowed.
+	DeniedVerbs []VerbRule `yaml:"deniedVerbs" json:"deniedVerbs,omitempty"`
+}
+
+// Resource - matches a GroupVersionResource. Empty fields and "*" match
+// anything.
+type Resource struct {
+	Group    string `yaml:"group" json:"group,omitempty"`
+	Version  string `yaml:"version" json:"version,omitempty"`
+	Resource string `yaml:"resource" json:"resource,omitempty"`
+}
+
+// VerbRule - matches requests with one of the verbs on the resource.
+type VerbRule struct {
+	Verbs    []string `yaml:"verbs" json:"verbs"`
+	Resource `yaml:",inline"`
+}
+
+func matches(pattern, value string) bool {
+	return pattern == "" || pattern == Wildcard || pattern == value
+}
+
+func (r Resource) matches(info *k8sRequest.RequestInfo) bool {
+	return matches(r.Group, info.APIGroup) && matches(r.Version, info.APIVersion) && matches(r.Resource, info.Resource)
+}
+
+func (v VerbRule) matches(info *k8sRequest.RequestInfo) bool {
+	if !v.Resource.matches(info) {
+		return false
+	}
+	for _, verb := range v.Verbs {
+		if matches(verb, info.Verb) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns an error describing why the request is not allowed for a run
+// owned by a CR in ownerNamespace, or nil if it is allowed.
+func (p *Policy) Check(ownerNamespace string, info *k8sRequest.RequestInfo) error {
+	if !info.IsResourceRequest || readVerbs.Has(info.Verb) {
+		return nil
+	}
+	for _, rule := range p.DeniedVerbs {
+		if rule.matches(info) {
+			return fmt.Errorf("verb %q is denied for resource %q by the watch policy", info.Verb, info.Resource)
+		}
+	}
+	if len(p.AllowedResources) != 0 {
+		allowed := false
+		for _, r := range p.AllowedResources {
+			if r.matches(info) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("resource %q in group %q is not allowed by the watch policy", info.Resource, info.APIGroup)
+		}
+	}
+
+	namespace := info.Namespace
+	// Namespaces themselves are cluster scoped, even though the request info
+	// carries their name as the namespace.
+	if info.APIGroup == "" && info.Resource == "namespaces" {
+		namespace = ""
+	}
+	if namespace == "" {
+		if !p.AllowClusterScoped {
+			return fmt.Errorf("writes to cluster scoped resource %q are not allowed by the watch policy", info.Resource)
+		}
+		return nil
+	}
+	if namespace == ownerNamespace {
+		return nil
+	}
+	for _, ns := range p.AllowedNamespaces {
+		if ns == Wildcard || ns == namespace {
+			return nil
+		}
+	}
+	return fmt.Errorf("writes to namespace %q are not allowed by the watch policy, only namespace %q and %v", namespace, ownerNamespace, p.AllowedNamespaces)
+}
@@ -0,0 +1,480 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+// This file contains this project's custom code, as opposed to kubectl.go
+// which contains code retrieved from the kubernetes project.
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+
+	"github.com/water-hole/ansible-operator/pkg/ansible/proxy/kubeconfig"
+	k8sRequest "github.com/water-hole/ansible-operator/pkg/ansible/proxy/requestfactory"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// CacheResponseHandler will handle proxied requests and check if the requested
+// resource exists in our cache. If it does then there is no need to bombard
+// the APIserver with our request and we should write the response from the
+// proxy. The uncachedKinds are always read from the APIserver.
+func CacheResponseHandler(h http.Handler, informerCache client.Reader, restMapper meta.RESTMapper, uncachedKinds []schema.GroupKind) http.Handler {
+	uncached := map[schema.GroupKind]bool{}
+	for _, gk := range uncachedKinds {
+		uncached[gk] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet:
+			// Requests made on behalf of another user must be authorized by
+			// the API server, so they can't be served from our cache.
+			if req.Header.Get(impersonateUserHeader) != "" {
+				break
+			}
+			// GET request means we need to check the cache
+			rf := k8sRequest.RequestInfoFactory{APIPrefixes: sets.NewString("api", "apis"), GrouplessAPIPrefixes: sets.NewString("api")}
+			r, err := rf.NewRequestInfo(req)
+			if err != nil {
+				log.Error(err, "failed to convert request")
+				break
+			}
+
+			// check if resource is present on request
+			if !r.IsResourceRequest {
+				break
+			}
+
+			if strings.HasPrefix(r.Path, "/version") {
+				// Temporarily pass along to API server
+				// Ideally we cache this response as well
+				break
+			}
+
+			gvr := schema.GroupVersionResource{
+				Group:    r.APIGroup,
+				Version:  r.APIVersion,
+				Resource: r.Resource,
+			}
+			if restMapper == nil {
+				restMapper = meta.NewDefaultRESTMapper([]schema.GroupVersion{schema.GroupVersion{
+					Group:   r.APIGroup,
+					Version: r.APIVersion,
+				}})
+			}
+
+			k, err := restMapper.KindFor(gvr)
+			if err != nil {
+				// break here in case resource doesn't exist in cache
+				log.Info("cache miss", "GVR", gvr)
+				break
+			}
+			if uncached[k.GroupKind()] {
+				break
+			}
+
+			un := unstructured.Unstructured{}
+			un.SetGroupVersionKind(k)
+			obj := client.ObjectKey{Namespace: r.Namespace, Name: r.Name}
+			err = informerCache.Get(context.Background(), obj, &un)
+			if err != nil {
+				// break here in case resource doesn't exist in cache but exists on APIserver
+				// This is very unlikely but provides user with expected 404
+				log.Info(fmt.Sprintf("cache miss: %v, %v", k, obj))
+				break
+			}
+
+			i := bytes.Buffer{}
+			resp, err := json.Marshal(un.Object)
+			if err != nil {
+				// return will give a 500
+				log.Error(err, "failed to marshal data")
+				http.Error(w, "", http.StatusInternalServerError)
+				return
+			}
+
+			// Set X-Cache header to signal that response is served from Cache
+			w.Header().Set("X-Cache", "HIT")
+			json.Indent(&i, resp, "", "  ")
+			_, err = w.Write(i.Bytes())
+			if err != nil {
+				log.Error(err, "failed to write response")
+				http.Error(w, "", http.StatusInternalServerError)
+				return
+			}
+
+			// Return so that request isn't passed along to APIserver
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// InjectOwnerReferenceHandler will handle proxied requests and inject the
+// owner refernece found in the authorization header. The Authorization is
+// then deleted so that the proxy can re-set with the correct authorization.
+func InjectOwnerReferenceHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPost {
+			log.Info("injecting owner reference")
+			dump, _ := httputil.DumpRequest(req, false)
+			log.V(1).Info("dumping request", "RequestDump", string(dump))
+
+			user, _, ok := req.BasicAuth()
+			if !ok {
+				log.Error(errors.New("basic auth header not found"), "")
+				w.Header().Set("WWW-Authenticate", "Basic realm=\"Operator Proxy\"")
+				http.Error(w, "", http.StatusUnauthorized)
+				return
+			}
+			authString, err := base64.StdEncoding.DecodeString(user)
+			if err != nil {
+				m := "could not base64 decode username"
+				log.Error(err, m)
+				http.Error(w, m, http.StatusBadRequest)
+				return
+			}
+			owner := metav1.OwnerReference{}
+			json.Unmarshal(authString, &owner)
+
+			log.V(1).Info(fmt.Sprintf("%#+v", owner))
+
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				m := "could not read request body"
+				log.Error(err, m)
+				http.Error(w, m, http.StatusInternalServerError)
+				return
+			}
+			data := &unstructured.Unstructured{}
+			err = json.Unmarshal(body, data)
+			if err != nil {
+				m := "could not deserialize request body"
+				log.Error(err, m)
+				http.Error(w, m, http.StatusBadRequest)
+				return
+			}
+			data.SetOwnerReferences(append(data.GetOwnerReferences(), owner))
+			newBody, err := json.Marshal(data.Object)
+			if err != nil {
+				m := "could not serialize body"
+				log.Error(err, m)
+				http.Error(w, m, http.StatusInternalServerError)
+				return
+			}
+			log.V(1).Info("serialized body", "Body", string(newBody))
+			req.Body = ioutil.NopCloser(bytes.NewBuffer(newBody))
+			req.ContentLength = int64(len(newBody))
+		}
+		// Removing the authorization so that the proxy can set the correct authorization.
+		req.Header.Del("Authorization")
+		h.ServeHTTP(w, req)
+	})
+}
+
+const (
+	impersonateUserHeader  = "Impersonate-User"
+	impersonateGroupHeader = "Impersonate-Group"
+)
+
+// runInfoKey is the key of the RunInfo in the context of a request.
+type runInfoKey struct{}
+
+// runInfoFromRequest returns the RunInfo that RunHandler found for the
+// request, if there is one.
+func runInfoFromRequest(req *http.Request) (kubeconfig.RunInfo, bool) {
+	info, ok := req.Context().Value(runInfoKey{}).(kubeconfig.RunInfo)
+	return info, ok
+}
+
+// RunHandler will handle proxied requests and reject the ones whose password
+// isn't the token of a run in progress. The RunInfo registered for the run is
+// passed to the next handlers with the request, so that they never decide
+// anything from the credentials ansible sends.
+func RunHandler(h http.Handler, runs *kubeconfig.Runs) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, token, _ := req.BasicAuth()
+		info, ok := runs.Get(token)
+		if !ok {
+			log.Info("rejecting request without the token of a run in progress", "Request.Method", req.Method, "Request.URL", req.URL.Path)
+			w.Header().Set("WWW-Authenticate", "Basic realm=\"Operator Proxy\"")
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), runInfoKey{}, info)))
+	})
+}
+
+// ImpersonationHandler will handle proxied requests and set the impersonation
+// headers from the RunInfo of the run, so that the API server authorizes each
+// request as the user the run is scoped to.
+func ImpersonationHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// Never trust impersonation headers set by the client.
+		for name := range req.Header {
+			if strings.HasPrefix(name, "Impersonate-") {
+				req.Header.Del(name)
+			}
+		}
+		info, ok := runInfoFromRequest(req)
+		if ok && info.ImpersonateUser != "" {
+			log.V(1).Info("impersonating user", "User", info.ImpersonateUser, "Groups", info.ImpersonateGroups)
+			req.Header.Set(impersonateUserHeader, info.ImpersonateUser)
+			for _, group := range info.ImpersonateGroups {
+				req.Header.Add(impersonateGroupHeader, group)
+			}
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// PolicyHandler will handle proxied requests and refuse the ones that are not
+// allowed by the policy in the RunInfo of the run. The policy is the one of the
+// watch, registered by the operator. A request without a run is refused, since
+// its policy can't be known.
+func PolicyHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		info, ok := runInfoFromRequest(req)
+		if !ok {
+			log.Info("request denied without a run", "Request.Method", req.Method, "Request.URL", req.URL.Path)
+			http.Error(w, "no run in progress for the request", http.StatusForbidden)
+			return
+		}
+		if info.Policy == nil {
+			h.ServeHTTP(w, req)
+			return
+		}
+		rf := k8sRequest.RequestInfoFactory{APIPrefixes: sets.NewString("api", "apis"), GrouplessAPIPrefixes: sets.NewString("api")}
+		r, err := rf.NewRequestInfo(req)
+		if err != nil {
+			log.Error(err, "failed to convert request")
+			http.Error(w, "could not parse request", http.StatusBadRequest)
+			return
+		}
+		err = info.Policy.Check(info.Namespace, r)
+		if err != nil {
+			log.Info("request denied by policy", "Request.Method", req.Method, "Request.URL", req.URL.Path, "Reason", err.Error())
+			status := apierrors.NewForbidden(schema.GroupResource{Group: r.APIGroup, Resource: r.Resource}, r.Name, err).Status()
+			body, err := json.Marshal(status)
+			if err != nil {
+				log.Error(err, "failed to marshal status")
+				http.Error(w, "", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write(body)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// DryRunHandler will handle proxied requests and turn every write of a run
+// whose RunInfo asks for a dry run into a server-side dry run. The operator
+// decides the dry run when it registers the run, and a write without a run
+// is refused, as it might belong to a dry run.
+func DryRunHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodGet || req.Method == http.MethodHead {
+			h.ServeHTTP(w, req)
+			return
+		}
+		info, ok := runInfoFromRequest(req)
+		if !ok {
+			log.Info("write denied without a run", "Request.Method", req.Method, "Request.URL", req.URL.Path)
+			http.Error(w, "no run in progress for the request", http.StatusForbidden)
+			return
+		}
+		if info.DryRun {
+			log.V(1).Info("sending request as dry run", "Request.Method", req.Method, "Request.URL", req.URL.Path)
+			q := req.URL.Query()
+			q.Set("dryRun", metav1.DryRunAll)
+			req.URL.RawQuery = q.Encode()
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// StatusKeysHandler will handle proxied requests and convert the keys of the
+// status a run writes for the CR that owns it to camelCase, with the
+// Converter of its RunInfo. Ansible facts are snake_case, the Converter
+// restores the form the CRD schema gives them.
+func StatusKeysHandler(h http.Handler, restMapper meta.RESTMapper) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPut && req.Method != http.MethodPatch {
+			h.ServeHTTP(w, req)
+			return
+		}
+		info, ok := runInfoFromRequest(req)
+		if !ok || info.Converter == nil || req.Header.Get("Content-Type") == string(types.JSONPatchType) {
+			h.ServeHTTP(w, req)
+			return
+		}
+		rf := k8sRequest.RequestInfoFactory{APIPrefixes: sets.NewString("api", "apis"), GrouplessAPIPrefixes: sets.NewString("api")}
+		r, err := rf.NewRequestInfo(req)
+		if err != nil || !r.IsResourceRequest || r.Subresource != "status" || !ownsRequest(info, r, restMapper) {
+			h.ServeHTTP(w, req)
+			return
+		}
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			m := "could not read request body"
+			log.Error(err, m)
+			http.Error(w, m, http.StatusInternalServerError)
+			return
+		}
+		obj := map[string]interface{}{}
+		if err := json.Unmarshal(body, &obj); err == nil {
+			if status, ok := obj["status"].(map[string]interface{}); ok {
+				obj["status"] = info.Converter.MapToCamel(status)
+				if b, err := json.Marshal(obj); err == nil {
+					body = b
+				}
+			}
+		}
+		req.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+		req.ContentLength = int64(len(body))
+		h.ServeHTTP(w, req)
+	})
+}
+
+// ownsRequest - whether the request is for the CR that owns the run.
+func ownsRequest(info kubeconfig.RunInfo, r *k8sRequest.RequestInfo, restMapper meta.RESTMapper) bool {
+	gv, err := schema.ParseGroupVersion(info.Owner.APIVersion)
+	if err != nil || r.APIGroup != gv.Group || r.Name != info.Owner.Name || r.Namespace != info.OwnerNamespace {
+		return false
+	}
+	if restMapper == nil {
+		return true
+	}
+	gvk, err := restMapper.KindFor(schema.GroupVersionResource{Group: r.APIGroup, Version: r.APIVersion, Resource: r.Resource})
+	return err == nil && gvk.Kind == info.Owner.Kind
+}
+
+// HandlerChain will be used for users to pass defined handlers to the proxy.
+// The hander chain will be run after InjectingOwnerReference if it is added
+// and before the proxy handler.
+type HandlerChain func(http.Handler) http.Handler
+
+// Options will be used by the user to specify the desired details
+// for the proxy.
+type Options struct {
+	Address          string
+	Port             int
+	Handler          HandlerChain
+	NoOwnerInjection bool
+	KubeConfig       *rest.Config
+	// Cache serves the GET requests of the objects it holds. A
+	// MultiNamespaceReader combines the caches of several namespaces.
+	Cache      client.Reader
+	RESTMapper meta.RESTMapper
+	// UncachedKinds are never served from the Cache, e.g. the watched
+	// kinds, whose informers only hold the CRs the controllers select.
+	UncachedKinds []schema.GroupKind
+	// AuditLog, if set, receives an AuditEntry for every mutating request.
+	AuditLog io.Writer
+	// AuditRequestBodies records the request bodies in the audit log.
+	AuditRequestBodies bool
+	// RateLimit limits the requests sent to the API server.
+	RateLimit RateLimit
+	// Runs holds the runs in progress. Only requests with the token of one
+	// of them are proxied.
+	Runs *kubeconfig.Runs
+}
+
+// Run will start a proxy server in a go routine that returns on the error
+// channel if something is not correct on startup. Run will not return until
+// the network socket is listening.
+func Run(done chan error, o Options) error {
+	if o.Runs == nil {
+		return errors.New("the proxy needs the runs in progress to authenticate requests")
+	}
+	server, err := newServer("/", o.KubeConfig)
+	if err != nil {
+		return err
+	}
+	if o.Handler != nil {
+		server.Handler = o.Handler(server.Handler)
+	}
+
+	if o.Cache == nil {
+		// Need to initialize cache since we don't have one
+		log.Info("Initializing and starting informer cache...")
+		informerCache, err := cache.New(o.KubeConfig, cache.Options{})
+		if err != nil {
+			return err
+		}
+		stop := make(chan struct{})
+		go func() {
+			informerCache.Start(stop)
+			defer close(stop)
+		}()
+		log.Info("Waiting for cache to sync...")
+		synced := informerCache.WaitForCacheSync(stop)
+		if !synced {
+			return fmt.Errorf("failed to sync cache")
+		}
+		log.Info("Cache sync was successful")
+		o.Cache = informerCache
+	}
+
+	if !o.NoOwnerInjection {
+		server.Handler = InjectOwnerReferenceHandler(server.Handler)
+	}
+	if o.RateLimit.QPS > 0 || o.RateLimit.JobQPS > 0 {
+		server.Handler = RateLimitHandler(server.Handler, o.RateLimit, o.RESTMapper)
+	}
+	// Always add cache handler
+	server.Handler = CacheResponseHandler(server.Handler, o.Cache, o.RESTMapper, o.UncachedKinds)
+	server.Handler = StatusKeysHandler(server.Handler, o.RESTMapper)
+	server.Handler = DryRunHandler(server.Handler)
+	// Always enforce the policy of the run, if it has one
+	server.Handler = PolicyHandler(server.Handler)
+	// Impersonation must be resolved before the cache is consulted.
+	server.Handler = ImpersonationHandler(server.Handler)
+	if o.AuditLog != nil {
+		server.Handler = AuditHandler(server.Handler, o.AuditLog, o.AuditRequestBodies)
+	}
+	// Every other handler relies on the RunInfo of the run.
+	server.Handler = RunHandler(server.Handler, o.Runs)
+
+	l, err := server.Listen(o.Address, o.Port)
+	if err != nil {
+		return err
+	}
+	go func() {
+		log.Info("Starting to serve", "Address", l.Addr().String())
+		done <- server.ServeOnListener(l)
+	}()
+	return nil
+}
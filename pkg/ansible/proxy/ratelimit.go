@@ -0,0 +1,163 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	k8sRequest "github.com/water-hole/ansible-operator/pkg/ansible/proxy/requestfactory"
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// jobLimiterTTL is how long the limiter of a job is kept after its last
+// request.
+const jobLimiterTTL = 10 * time.Minute
+
+var (
+	throttledRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ansible_operator_proxy_throttled_requests_total",
+		Help: "Number of requests through the ansible proxy that were delayed by rate limiting.",
+	}, []string{"group", "version", "kind"})
+	throttledSeconds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ansible_operator_proxy_throttled_seconds_total",
+		Help: "Total time requests through the ansible proxy spent waiting on rate limiting.",
+	}, []string{"group", "version", "kind"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(throttledRequests, throttledSeconds)
+}
+
+// RateLimit - limits the rate of requests the proxy sends to the API server.
+// A QPS of zero disables the corresponding limit.
+type RateLimit struct {
+	// QPS and Burst limit the requests of all runs together.
+	QPS   float32
+	Burst int
+	// JobQPS and JobBurst limit the requests of each run.
+	JobQPS   float32
+	JobBurst int
+}
+
+type jobLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+type rateLimiter struct {
+	limit      RateLimit
+	restMapper meta.RESTMapper
+	overall    *rate.Limiter
+
+	mutex     sync.Mutex
+	jobs      map[string]*jobLimiter
+	lastPurge time.Time
+}
+
+// limiterFor returns the limiter of the job, creating it if needed.
+func (r *rateLimiter) limiterFor(job string) *rate.Limiter {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := time.Now()
+	if now.Sub(r.lastPurge) > jobLimiterTTL {
+		for ident, jl := range r.jobs {
+			if now.Sub(jl.lastSeen) > jobLimiterTTL {
+				delete(r.jobs, ident)
+			}
+		}
+		r.lastPurge = now
+	}
+	jl, ok := r.jobs[job]
+	if !ok {
+		jl = &jobLimiter{limiter: rate.NewLimiter(rate.Limit(r.limit.JobQPS), r.limit.JobBurst)}
+		r.jobs[job] = jl
+	}
+	jl.lastSeen = now
+	return jl.limiter
+}
+
+// gvkLabels returns the metric labels for the request.
+func (r *rateLimiter) gvkLabels(info *k8sRequest.RequestInfo) prometheus.Labels {
+	gvr := schema.GroupVersionResource{Group: info.APIGroup, Version: info.APIVersion, Resource: info.Resource}
+	kind := info.Resource
+	if r.restMapper != nil {
+		if k, err := r.restMapper.KindFor(gvr); err == nil {
+			kind = k.Kind
+		}
+	}
+	return prometheus.Labels{"group": info.APIGroup, "version": info.APIVersion, "kind": kind}
+}
+
+// RateLimitHandler will handle proxied requests and delay them until the
+// overall limit and the limit of their run allow them to be sent. Requests
+// are queued rather than refused. It is added after the cache handler so
+// reads served from the cache are never limited.
+func RateLimitHandler(h http.Handler, limit RateLimit, restMapper meta.RESTMapper) http.Handler {
+	// A limiter with a burst of zero never allows a request.
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	if limit.JobBurst < 1 {
+		limit.JobBurst = 1
+	}
+	r := &rateLimiter{
+		limit:      limit,
+		restMapper: restMapper,
+		jobs:       map[string]*jobLimiter{},
+	}
+	if limit.QPS > 0 {
+		r.overall = rate.NewLimiter(rate.Limit(limit.QPS), limit.Burst)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		limiters := []*rate.Limiter{}
+		if limit.JobQPS > 0 {
+			if info, ok := runInfoFromRequest(req); ok && info.Job != "" {
+				limiters = append(limiters, r.limiterFor(info.Job))
+			}
+		}
+		if r.overall != nil {
+			limiters = append(limiters, r.overall)
+		}
+
+		start := time.Now()
+		for _, l := range limiters {
+			if err := l.Wait(req.Context()); err != nil {
+				// The client went away while the request was queued.
+				log.V(1).Info("rate limited request was cancelled", "Request.URL", req.URL.Path, "Error", err.Error())
+				http.Error(w, err.Error(), http.StatusTooManyRequests)
+				return
+			}
+		}
+		// Waits shorter than this are the limiter's own bookkeeping, not
+		// throttling.
+		if waited := time.Since(start); waited > time.Millisecond {
+			rf := k8sRequest.RequestInfoFactory{APIPrefixes: sets.NewString("api", "apis"), GrouplessAPIPrefixes: sets.NewString("api")}
+			if info, err := rf.NewRequestInfo(req); err == nil && info.IsResourceRequest {
+				labels := r.gvkLabels(info)
+				throttledRequests.With(labels).Inc()
+				throttledSeconds.With(labels).Add(waited.Seconds())
+			}
+			log.V(1).Info("request was throttled", "Request.Method", req.Method, "Request.URL", req.URL.Path, "Waited", waited.String())
+		}
+		h.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,160 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ansibleConfigEnvVar is the variable ansible reads the path of its config
+// file from, before any other location.
+const ansibleConfigEnvVar = "ANSIBLE_CONFIG"
+
+// systemAnsibleCfg is the config file ansible reads when no other is found,
+// where the operator image keeps its defaults.
+const systemAnsibleCfg = "/etc/ansible/ansible.cfg"
+
+func validateAnsibleCfg(sections map[string]map[string]string) error {
+	for name, section := range sections {
+		if name == "" || strings.ContainsAny(name, "[]\n") {
+			return fmt.Errorf("invalid ansibleCfg section %q", name)
+		}
+		for k, v := range section {
+			if k == "" || strings.ContainsAny(k, "=:\n") || strings.Contains(v, "\n") {
+				return fmt.Errorf("invalid ansibleCfg setting %q in section %v", k, name)
+			}
+		}
+	}
+	return nil
+}
+
+// baseAnsibleCfgPath returns the config file ansible would read in the
+// operator's environment without the ansibleCfg of a watch: the one of
+// ANSIBLE_CONFIG, ~/.ansible.cfg or /etc/ansible/ansible.cfg, in that order.
+// The config file of the project directory is generated, so it isn't one of
+// them. Empty if there is none.
+func baseAnsibleCfgPath() string {
+	paths := []string{os.Getenv(ansibleConfigEnvVar)}
+	if home := os.Getenv("HOME"); home != "" {
+		paths = append(paths, filepath.Join(home, ".ansible.cfg"))
+	}
+	for _, p := range append(paths, systemAnsibleCfg) {
+		if p == "" {
+			continue
+		}
+		if fi, err := os.Stat(p); err == nil && !fi.IsDir() {
+			return p
+		}
+	}
+	return ""
+}
+
+// mergeAnsibleCfg returns the settings of the config file ansible would read
+// otherwise, e.g. the defaults of the operator image, with the settings of
+// the watch applied over them.
+func mergeAnsibleCfg(overrides map[string]map[string]string) (map[string]map[string]string, error) {
+	sections := map[string]map[string]string{}
+	if path := baseAnsibleCfgPath(); path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		sections, err = parseAnsibleCfg(b)
+		if err != nil {
+			return nil, fmt.Errorf("%v in %v", err, path)
+		}
+	}
+	for name, section := range overrides {
+		if sections[name] == nil {
+			sections[name] = map[string]string{}
+		}
+		for k, v := range section {
+			sections[name][strings.ToLower(k)] = v
+		}
+	}
+	return sections, nil
+}
+
+// parseAnsibleCfg parses an ini file the way ansible does: keys are case
+// insensitive and separated from their values by "=" or ":", lines starting
+// with "#" or ";" are comments, and indented lines continue the value of the
+// previous key.
+func parseAnsibleCfg(b []byte) (map[string]map[string]string, error) {
+	sections := map[string]map[string]string{}
+	var section map[string]string
+	var key string
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";"):
+			continue
+		case line[0] == ' ' || line[0] == '\t':
+			if section == nil || key == "" {
+				return nil, fmt.Errorf("unexpected indented line %v", n)
+			}
+			section[key] += "\n" + trimmed
+		case strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]"):
+			name := strings.TrimSpace(trimmed[1 : len(trimmed)-1])
+			if sections[name] == nil {
+				sections[name] = map[string]string{}
+			}
+			section = sections[name]
+			key = ""
+		default:
+			i := strings.IndexAny(trimmed, "=:")
+			if section == nil || i <= 0 {
+				return nil, fmt.Errorf("invalid line %v", n)
+			}
+			key = strings.ToLower(strings.TrimSpace(trimmed[:i]))
+			section[key] = strings.TrimSpace(trimmed[i+1:])
+		}
+	}
+	return sections, scanner.Err()
+}
+
+// ansibleCfg renders the ansible.cfg sections of a watch as an ini file,
+// sorted so that the file is stable across runs.
+func ansibleCfg(sections map[string]map[string]string) []byte {
+	var b bytes.Buffer
+	names := make([]string, 0, len(sections))
+	for name := range sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "[%s]\n", name)
+		keys := make([]string, 0, len(sections[name]))
+		for k := range sections[name] {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			// The lines of a value read from a file continue indented.
+			v := strings.Replace(sections[name][k], "\n", "\n    ", -1)
+			fmt.Fprintf(&b, "%s = %s\n", k, v)
+		}
+		b.WriteString("\n")
+	}
+	return b.Bytes()
+}
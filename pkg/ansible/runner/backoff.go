@@ -0,0 +1,108 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+const (
+	defaultBackoffBase = 5 * time.Second
+	defaultBackoffCap  = 10 * time.Minute
+)
+
+// backoff - the backoff of a watch as written in watches.yaml.
+type backoff struct {
+	Base       string  `yaml:"base"`
+	Cap        string  `yaml:"cap"`
+	Jitter     float64 `yaml:"jitter"`
+	MaxRetries int     `yaml:"maxRetries"`
+}
+
+// Backoff - how failed runs of a CR are retried.
+type Backoff struct {
+	// Base is the delay after the first failure, doubled for each further
+	// failure in a row.
+	Base time.Duration
+	// Cap is the longest delay.
+	Cap time.Duration
+	// Jitter adds up to this fraction of the delay at random, so that CRs
+	// failing together don't retry together.
+	Jitter float64
+	// MaxRetries, if positive, is the number of retries of a CR after its
+	// first failure; once they failed too, the CR is no longer retried until
+	// its spec changes.
+	MaxRetries int
+}
+
+// parse validates the backoff of a watch and applies the defaults.
+func (b *backoff) parse() (*Backoff, error) {
+	if b == nil {
+		return nil, nil
+	}
+	p := &Backoff{
+		Base:       defaultBackoffBase,
+		Cap:        defaultBackoffCap,
+		Jitter:     b.Jitter,
+		MaxRetries: b.MaxRetries,
+	}
+	var err error
+	if b.Base != "" {
+		p.Base, err = time.ParseDuration(b.Base)
+		if err != nil {
+			return nil, fmt.Errorf("invalid backoff base: %v", err)
+		}
+	}
+	if b.Cap != "" {
+		p.Cap, err = time.ParseDuration(b.Cap)
+		if err != nil {
+			return nil, fmt.Errorf("invalid backoff cap: %v", err)
+		}
+	}
+	if p.Base <= 0 || p.Cap < p.Base {
+		return nil, fmt.Errorf("backoff base must be positive and not above the cap")
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return nil, fmt.Errorf("backoff jitter must be between 0 and 1")
+	}
+	if p.MaxRetries < 0 {
+		return nil, fmt.Errorf("backoff maxRetries must not be negative")
+	}
+	return p, nil
+}
+
+// Delay - the delay before retrying a CR that failed the given number of
+// times in a row.
+func (b Backoff) Delay(failures int) time.Duration {
+	d := b.Base
+	for i := 1; i < failures && d < b.Cap; i++ {
+		d *= 2
+	}
+	if d > b.Cap {
+		d = b.Cap
+	}
+	if b.Jitter > 0 {
+		d += time.Duration(b.Jitter * rand.Float64() * float64(d))
+	}
+	return d
+}
+
+// LimitExceeded - whether a CR that failed the given number of times in a row
+// is no longer retried.
+func (b Backoff) LimitExceeded(failures int) bool {
+	return b.MaxRetries > 0 && failures > b.MaxRetries
+}
@@ -0,0 +1,63 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/ghodss/yaml"
+	"github.com/water-hole/ansible-operator/pkg/ansible/paramconv"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// newConverterForCRD returns the Converter of the keys of the
+// openAPIV3Schema the CRD manifest at path declares for gvk: the schema of
+// the version, or the one of the CRD.
+func newConverterForCRD(path string, gvk schema.GroupVersionKind) (*paramconv.Converter, error) {
+	if !filepath.IsAbs(path) {
+		return nil, fmt.Errorf("crd path must be absolute")
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	crd := map[string]interface{}{}
+	if err := yaml.Unmarshal(b, &crd); err != nil {
+		return nil, fmt.Errorf("invalid crd %v: %v", path, err)
+	}
+	group, _, _ := unstructured.NestedString(crd, "spec", "group")
+	kind, _, _ := unstructured.NestedString(crd, "spec", "names", "kind")
+	if crd["kind"] != "CustomResourceDefinition" || group != gvk.Group || kind != gvk.Kind {
+		return nil, fmt.Errorf("%v is not the CustomResourceDefinition of %v", path, gvk.GroupKind())
+	}
+	versions, _, _ := unstructured.NestedSlice(crd, "spec", "versions")
+	for _, v := range versions {
+		v, ok := v.(map[string]interface{})
+		if !ok || v["name"] != gvk.Version {
+			continue
+		}
+		if s, ok, _ := unstructured.NestedMap(v, "schema", "openAPIV3Schema"); ok {
+			return paramconv.NewConverterForSchema(s)
+		}
+	}
+	s, ok, _ := unstructured.NestedMap(crd, "spec", "validation", "openAPIV3Schema")
+	if !ok {
+		return nil, fmt.Errorf("%v has no openAPIV3Schema for %v", path, gvk.Version)
+	}
+	return paramconv.NewConverterForSchema(s)
+}
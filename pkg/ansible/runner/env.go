@@ -0,0 +1,106 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"fmt"
+	"os"
+)
+
+// EnvSource - the value of an environment variable of the runs of a watch.
+// A plain string in watches.yaml sets Value.
+type EnvSource struct {
+	// Value is the literal value of the variable.
+	Value string `yaml:"value"`
+	// FromEnv is the name of a variable of the operator's environment to
+	// copy. The variable is left unset if the operator doesn't have it.
+	FromEnv string `yaml:"fromEnv"`
+	// SecretKeyRef reads the value from a Secret in the namespace of the CR.
+	SecretKeyRef *KeyRef `yaml:"secretKeyRef"`
+}
+
+// UnmarshalYAML - implements the yaml.Unmarshaler interface
+func (e *EnvSource) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err == nil {
+		e.Value = value
+		return nil
+	}
+
+	// hide EnvSource data in plain struct to prevent unmarshal from calling
+	// UnmarshalYAML again
+	type plain EnvSource
+
+	return unmarshal((*plain)(e))
+}
+
+// operatorEnvVars are set by the operator for every run and can't be
+// overridden by a watch.
+var operatorEnvVars = map[string]bool{
+	"K8S_AUTH_KUBECONFIG": true,
+}
+
+func validateEnv(env map[string]EnvSource) error {
+	for name, e := range env {
+		if operatorEnvVars[name] {
+			return fmt.Errorf("env %v is set by the operator", name)
+		}
+		sources := 0
+		if e.Value != "" {
+			sources++
+		}
+		if e.FromEnv != "" {
+			sources++
+		}
+		if e.SecretKeyRef != nil {
+			sources++
+			if e.SecretKeyRef.Key == "" {
+				return fmt.Errorf("env %v must have a key", name)
+			}
+			if e.SecretKeyRef.Name == "" && e.SecretKeyRef.NameFromSpec == "" {
+				return fmt.Errorf("env %v must have a name or nameFromSpec", name)
+			}
+			if e.SecretKeyRef.Namespace != "" {
+				return fmt.Errorf("env %v can't set a namespace, it is read from the namespace of the CR", name)
+			}
+		}
+		if sources > 1 {
+			return fmt.Errorf("env %v must have only one of value, fromEnv or secretKeyRef", name)
+		}
+	}
+	return nil
+}
+
+// envVarsFor returns the environment of a run: the env of the watch, then the
+// values read from Secrets, then the variables of the operator, which take
+// precedence.
+func (r *runner) envVarsFor(kubeconfig string, opts RunOptions) map[string]string {
+	envVars := map[string]string{}
+	for name, e := range r.env {
+		switch {
+		case e.FromEnv != "":
+			if v, ok := os.LookupEnv(e.FromEnv); ok {
+				envVars[name] = v
+			}
+		case e.SecretKeyRef == nil:
+			envVars[name] = e.Value
+		}
+	}
+	for name, v := range opts.SecretEnv {
+		envVars[name] = v
+	}
+	envVars["K8S_AUTH_KUBECONFIG"] = kubeconfig
+	return envVars
+}
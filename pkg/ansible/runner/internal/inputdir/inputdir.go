@@ -0,0 +1,272 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inputdir
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+var log = logf.Log.WithName("inputdir")
+
+// InputDir represents an input directory for ansible-runner.
+type InputDir struct {
+	Path         string
+	PlaybookPath string
+	Parameters   map[string]interface{}
+	EnvVars      map[string]string
+	Settings     map[string]string
+	// CmdLine holds extra arguments that ansible-runner passes to ansible.
+	CmdLine []string
+	// SecretParameters are the keys of Parameters holding secrets. When
+	// set, the parameters are only readable by the operator.
+	SecretParameters []string
+	// SecretEnvVars are the keys of EnvVars holding secrets, handled like
+	// SecretParameters.
+	SecretEnvVars []string
+	// AnsibleCfg is written to the ansible.cfg of the project when set.
+	AnsibleCfg []byte
+	// VaultPassword is written to VaultPasswordPath for the run when set.
+	VaultPassword []byte
+}
+
+// ansibleCfgFile is where AnsibleCfg is written.
+const ansibleCfgFile = "project/ansible.cfg"
+
+// vaultPasswordFile is where the vault password is written.
+const vaultPasswordFile = "env/vault_password"
+
+// redactedValue replaces the values of secret parameters.
+const redactedValue = "REDACTED"
+
+// makeDirs creates the required directory structure.
+func (i *InputDir) makeDirs() error {
+	for _, path := range []string{"env", "project", "inventory"} {
+		fullPath := filepath.Join(i.Path, path)
+		err := os.MkdirAll(fullPath, os.ModePerm)
+		if err != nil {
+			log.Error(err, "unable to create directory", "Path", fullPath)
+			return err
+		}
+	}
+	return nil
+}
+
+// addFile adds a file to the given relative path within the input directory.
+func (i *InputDir) addFile(path string, content []byte) error {
+	fullPath := filepath.Join(i.Path, path)
+	err := ioutil.WriteFile(fullPath, content, 0644)
+	if err != nil {
+		log.Error(err, "unable to write file", "Path", fullPath)
+	}
+	return err
+}
+
+// addOptionalFile adds a file to the given relative path within the input
+// directory if content is not empty, and otherwise removes any file left
+// there by a previous run.
+func (i *InputDir) addOptionalFile(path string, content []byte) error {
+	if len(content) != 0 {
+		return i.addFile(path, content)
+	}
+	fullPath := filepath.Join(i.Path, path)
+	err := os.Remove(fullPath)
+	if err != nil && !os.IsNotExist(err) {
+		log.Error(err, "unable to remove file", "Path", fullPath)
+		return err
+	}
+	return nil
+}
+
+// addPrivateFile adds a file only readable by the operator to the given
+// relative path within the input directory.
+func (i *InputDir) addPrivateFile(path string, content []byte) error {
+	fullPath := filepath.Join(i.Path, path)
+	// WriteFile doesn't change the mode of an existing file.
+	err := os.Remove(fullPath)
+	if err != nil && !os.IsNotExist(err) {
+		log.Error(err, "unable to remove file", "Path", fullPath)
+		return err
+	}
+	err = ioutil.WriteFile(fullPath, content, 0600)
+	if err != nil {
+		log.Error(err, "unable to write file", "Path", fullPath)
+	}
+	return err
+}
+
+// RedactSecrets replaces the values of the secret parameters and environment
+// variables written to disk, once ansible-runner no longer needs them.
+func (i *InputDir) RedactSecrets() error {
+	if len(i.SecretParameters) != 0 {
+		parameters := make(map[string]interface{}, len(i.Parameters))
+		for k, v := range i.Parameters {
+			parameters[k] = v
+		}
+		for _, k := range i.SecretParameters {
+			parameters[k] = redactedValue
+		}
+		paramBytes, err := json.Marshal(parameters)
+		if err != nil {
+			return err
+		}
+		err = i.addPrivateFile("env/extravars", paramBytes)
+		if err != nil {
+			return err
+		}
+	}
+	if len(i.SecretEnvVars) != 0 {
+		envVars := make(map[string]string, len(i.EnvVars))
+		for k, v := range i.EnvVars {
+			envVars[k] = v
+		}
+		for _, k := range i.SecretEnvVars {
+			envVars[k] = redactedValue
+		}
+		envVarBytes, err := json.Marshal(envVars)
+		if err != nil {
+			return err
+		}
+		err = i.addPrivateFile("env/envvars", envVarBytes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AnsibleCfgPath returns the path of the ansible.cfg of the project.
+func (i *InputDir) AnsibleCfgPath() string {
+	return filepath.Join(i.Path, ansibleCfgFile)
+}
+
+// VaultPasswordPath returns the path of the vault password of the run.
+func (i *InputDir) VaultPasswordPath() string {
+	return filepath.Join(i.Path, vaultPasswordFile)
+}
+
+// RemoveVaultPassword removes the vault password once the run no longer
+// needs it.
+func (i *InputDir) RemoveVaultPassword() error {
+	err := os.Remove(i.VaultPasswordPath())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Stdout reads the stdout from the ansible artifact that corresponds to the
+// given ident and returns it as a string.
+func (i *InputDir) Stdout(ident string) (string, error) {
+	errorPath := filepath.Join(i.Path, "artifacts", ident, "stdout")
+	errorText, err := ioutil.ReadFile(errorPath)
+	return string(errorText), err
+}
+
+// Write commits the object's state to the filesystem at i.Path.
+func (i *InputDir) Write() error {
+	paramBytes, err := json.Marshal(i.Parameters)
+	if err != nil {
+		return err
+	}
+	envVarBytes, err := json.Marshal(i.EnvVars)
+	if err != nil {
+		return err
+	}
+	settingsBytes, err := json.Marshal(i.Settings)
+	if err != nil {
+		return err
+	}
+
+	err = i.makeDirs()
+	if err != nil {
+		return err
+	}
+
+	if len(i.SecretEnvVars) != 0 {
+		err = i.addPrivateFile("env/envvars", envVarBytes)
+	} else {
+		err = i.addFile("env/envvars", envVarBytes)
+	}
+	if err != nil {
+		return err
+	}
+	if len(i.SecretParameters) != 0 {
+		err = i.addPrivateFile("env/extravars", paramBytes)
+	} else {
+		err = i.addFile("env/extravars", paramBytes)
+	}
+	if err != nil {
+		return err
+	}
+	err = i.addFile("env/settings", settingsBytes)
+	if err != nil {
+		return err
+	}
+	err = i.addOptionalFile("env/cmdline", []byte(strings.Join(i.CmdLine, " ")))
+	if err != nil {
+		return err
+	}
+	err = i.addOptionalFile(ansibleCfgFile, i.AnsibleCfg)
+	if err != nil {
+		return err
+	}
+	if len(i.VaultPassword) != 0 {
+		err = i.addPrivateFile(vaultPasswordFile, i.VaultPassword)
+	} else {
+		err = i.RemoveVaultPassword()
+	}
+	if err != nil {
+		return err
+	}
+
+	// If ansible-runner is running in a python virtual environment, propagate
+	// that to ansible.
+	venv := os.Getenv("VIRTUAL_ENV")
+	hosts := "localhost ansible_connection=local"
+	if venv != "" {
+		hosts = fmt.Sprintf("%s ansible_python_interpreter=%s", hosts, filepath.Join(venv, "bin/python"))
+	}
+	err = i.addFile("inventory/hosts", []byte(hosts))
+	if err != nil {
+		return err
+	}
+
+	if i.PlaybookPath != "" {
+		f, err := os.Open(i.PlaybookPath)
+		if err != nil {
+			log.Error(err, "failed to open playbook file", "Path", i.PlaybookPath)
+			return err
+		}
+		defer f.Close()
+
+		playbookBytes, err := ioutil.ReadAll(f)
+		if err != nil {
+			return err
+		}
+
+		err = i.addFile("project/playbook.yaml", playbookBytes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// TagsAnnotation - annotation used by a user to only run the tasks with the given comma
+	// separated tags, e.g. "ansible.operator-sdk/tags: backup".
+	TagsAnnotation = "ansible.operator-sdk/tags"
+	// SkipTagsAnnotation - annotation used by a user to skip the tasks with the given comma
+	// separated tags.
+	SkipTagsAnnotation = "ansible.operator-sdk/skip-tags"
+	// CheckAnnotation - annotation used by a user to run ansible in check mode, e.g.
+	// "ansible.operator-sdk/check: true".
+	CheckAnnotation = "ansible.operator-sdk/check"
+	// DiffAnnotation - annotation used by a user to run ansible in diff mode.
+	DiffAnnotation = "ansible.operator-sdk/diff"
+	// LimitAnnotation - annotation used by a user to limit the hosts ansible runs against.
+	LimitAnnotation = "ansible.operator-sdk/limit"
+	// VerbosityAnnotation - annotation used by a user to set the verbosity of ansible for the CR,
+	// e.g. "ansible.operator-sdk/verbosity: 4". This overrides the verbosity of the watch when the
+	// watch allows the "verbosity" override.
+	VerbosityAnnotation = "ansible.operator-sdk/verbosity"
+)
+
+const (
+	// defaultVerbosity is the verbosity of ansible when the watch does not
+	// set one, equivalent to "-vv".
+	defaultVerbosity = 2
+	// maxVerbosity is the highest verbosity ansible knows, "-vvvvvv".
+	maxVerbosity = 6
+)
+
+// overrideAnnotations maps the names used in the allowed overrides of a watch
+// to the annotation that overrides the option.
+var overrideAnnotations = map[string]string{
+	"tags":     TagsAnnotation,
+	"skipTags": SkipTagsAnnotation,
+	"check":    CheckAnnotation,
+	"diff":     DiffAnnotation,
+	"limit":    LimitAnnotation,
+	// The verbosity isn't one of the AnsibleOptions, verbosityFor
+	// applies it.
+	"verbosity": VerbosityAnnotation,
+}
+
+// AnsibleOptions - options that select what ansible does during a run.
+type AnsibleOptions struct {
+	Tags     []string `yaml:"tags" json:"tags,omitempty"`
+	SkipTags []string `yaml:"skipTags" json:"skipTags,omitempty"`
+	Check    bool     `yaml:"check" json:"check,omitempty"`
+	Diff     bool     `yaml:"diff" json:"diff,omitempty"`
+	Limit    string   `yaml:"limit" json:"limit,omitempty"`
+}
+
+// validateOverrides checks that every allowed override names an option.
+func validateOverrides(overrides []string) error {
+	for _, o := range overrides {
+		if _, ok := overrideAnnotations[o]; !ok {
+			return fmt.Errorf("unknown ansible option override: %v", o)
+		}
+	}
+	return nil
+}
+
+// ansibleOptionsFor returns the options for a run for the resource: the
+// defaults of the watch, overridden by the annotations of the resource that
+// the watch allows.
+func (r *runner) ansibleOptionsFor(u *unstructured.Unstructured) (AnsibleOptions, error) {
+	opts := r.ansibleOptions
+	annotations := u.GetAnnotations()
+	for _, o := range r.ansibleOptionsOverrides {
+		v, ok := annotations[overrideAnnotations[o]]
+		if !ok {
+			continue
+		}
+		var err error
+		switch o {
+		case "tags":
+			opts.Tags = splitList(v)
+		case "skipTags":
+			opts.SkipTags = splitList(v)
+		case "check":
+			opts.Check, err = strconv.ParseBool(v)
+		case "diff":
+			opts.Diff, err = strconv.ParseBool(v)
+		case "limit":
+			opts.Limit = v
+		}
+		if err != nil {
+			return opts, fmt.Errorf("invalid %v annotation: %v", overrideAnnotations[o], err)
+		}
+	}
+	return opts, nil
+}
+
+// allowsOverride returns whether a CR may override the option with an
+// annotation.
+func (r *runner) allowsOverride(option string) bool {
+	for _, o := range r.ansibleOptionsOverrides {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// cmdLine returns the arguments ansible-runner passes to ansible for the
+// options.
+func (o AnsibleOptions) cmdLine() []string {
+	args := []string{}
+	if len(o.Tags) != 0 {
+		args = append(args, "--tags", shellQuote(strings.Join(o.Tags, ",")))
+	}
+	if len(o.SkipTags) != 0 {
+		args = append(args, "--skip-tags", shellQuote(strings.Join(o.SkipTags, ",")))
+	}
+	if o.Check {
+		args = append(args, "--check")
+	}
+	if o.Diff {
+		args = append(args, "--diff")
+	}
+	if o.Limit != "" {
+		args = append(args, "--limit", shellQuote(o.Limit))
+	}
+	return args
+}
+
+// parameterKeys - how the keys of the spec are passed to ansible.
+type parameterKeys int
+
+const (
+	// snakeCaseKeys converts the keys to snake_case, the default.
+	snakeCaseKeys parameterKeys = iota
+	// specKeys passes the keys unchanged.
+	specKeys
+	// bothKeys passes the top level keys both converted and unchanged.
+	bothKeys
+)
+
+// parseSnakeCaseParameters parses the snakeCaseParameters of a watch: a
+// boolean, or "both".
+func parseSnakeCaseParameters(s string) (parameterKeys, error) {
+	if s == "" {
+		return snakeCaseKeys, nil
+	}
+	if s == "both" {
+		return bothKeys, nil
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return snakeCaseKeys, fmt.Errorf("snakeCaseParameters must be true, false or both, got %q", s)
+	}
+	if b {
+		return snakeCaseKeys, nil
+	}
+	return specKeys, nil
+}
+
+// validateVerbosity checks that v is a verbosity ansible knows.
+func validateVerbosity(v int) error {
+	if v < 0 || v > maxVerbosity {
+		return fmt.Errorf("verbosity must be between 0 and %v, got %v", maxVerbosity, v)
+	}
+	return nil
+}
+
+// verbosityFor returns the verbosity of ansible for a run for the resource:
+// the verbosity of the watch, overridden by the annotation of the resource
+// if the watch allows it.
+func (r *runner) verbosityFor(u *unstructured.Unstructured) (int, error) {
+	v, ok := u.GetAnnotations()[VerbosityAnnotation]
+	if !ok || !r.allowsOverride("verbosity") {
+		return r.verbosity, nil
+	}
+	verbosity, err := strconv.Atoi(v)
+	if err == nil {
+		err = validateVerbosity(verbosity)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid %v annotation: %v", VerbosityAnnotation, err)
+	}
+	return verbosity, nil
+}
+
+// verbosityArgs returns the ansible-runner arguments for the verbosity.
+func verbosityArgs(verbosity int) []string {
+	if verbosity == 0 {
+		return []string{}
+	}
+	return []string{"-" + strings.Repeat("v", verbosity)}
+}
+
+// splitList splits a comma separated list, dropping empty items.
+func splitList(s string) []string {
+	l := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			l = append(l, item)
+		}
+	}
+	return l
+}
+
+// shellQuote quotes s for ansible-runner, which splits env/cmdline like a
+// shell does.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'"'"'`, -1) + "'"
+}
+
+// ReconcileParameter - the extra variable describing the reconciliation a
+// run is part of.
+const ReconcileParameter = "_reconcile"
+
+// Triggers of a reconciliation.
+const (
+	// TriggerCreate - the CR has not been reconciled before.
+	TriggerCreate = "create"
+	// TriggerUpdate - the spec of the CR changed since the last run.
+	TriggerUpdate = "update"
+	// TriggerResync - the CR is reconciled again without changes, after the
+	// reconcile period or to retry a failure.
+	TriggerResync = "resync"
+	// TriggerDelete - the CR is being deleted and the finalizer runs.
+	TriggerDelete = "delete"
+	// TriggerManual - a user asked for a run by changing the trigger
+	// annotation of the CR.
+	TriggerManual = "manual"
+)
+
+// ReconcileContext - what a run knows about the reconciliation it is part of,
+// passed to ansible as the ReconcileParameter extra variable.
+type ReconcileContext struct {
+	// Trigger is why the CR is reconciled. It is only set for deletions
+	// when the operator doesn't manage the status.
+	Trigger string `json:"trigger,omitempty"`
+	// LastResult is the ansible result of the previous run, if any.
+	LastResult interface{} `json:"last_result"`
+	// LastFailureMessage is the failure message of the previous run, if it
+	// failed.
+	LastFailureMessage string `json:"last_failure_message"`
+	// ConsecutiveFailures counts the runs that failed in a row before this
+	// one.
+	ConsecutiveFailures int `json:"consecutive_failures"`
+	// Status is the current status of the CR.
+	Status map[string]interface{} `json:"status"`
+}
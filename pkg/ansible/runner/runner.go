@@ -0,0 +1,911 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/water-hole/ansible-operator/pkg/ansible/events"
+	"github.com/water-hole/ansible-operator/pkg/ansible/paramconv"
+	"github.com/water-hole/ansible-operator/pkg/ansible/proxy/policy"
+	"github.com/water-hole/ansible-operator/pkg/ansible/runner/eventapi"
+	"github.com/water-hole/ansible-operator/pkg/ansible/runner/internal/inputdir"
+
+	"github.com/hashicorp/golang-lru/simplelru"
+	yaml "gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+var log = logf.Log.WithName("runner")
+
+// maxCollisionsLogged - the number of CRs whose spec key collisions a runner
+// remembers having logged, the least recently reported are forgotten first.
+const maxCollisionsLogged = 1024
+
+// Runner - a runnable that should take the parameters and name and namespace
+// and run the correct code.
+type Runner interface {
+	Run(string, *unstructured.Unstructured, string, RunOptions) (RunResult, error)
+	GetFinalizer() (string, bool)
+	GetReconcilePeriod() (time.Duration, bool)
+	GetManageStatus() bool
+	GetImpersonation() (string, bool)
+	GetPolicy() *policy.Policy
+	GetVerbosity() int
+	GetLogEvents() (events.LogLevel, bool)
+	GetVars() []VarSource
+	GetVaultPasswordSecret() *KeyRef
+	GetEnv() map[string]EnvSource
+	GetSelector() labels.Selector
+	GetNamespaces() []string
+	GetBackoff() *Backoff
+	GetSchedule() *Schedule
+	GetClusterScoped() (bool, bool)
+	GetDefaultNamespace() string
+	GetReadOnly() bool
+	GetConverter() *paramconv.Converter
+}
+
+// RunOptions - options for a single run of ansible, decided by the caller.
+type RunOptions struct {
+	// DryRun runs ansible in check mode, so that tasks report the changes
+	// they would make without making them.
+	DryRun bool
+	// Vars are extra variables resolved from the VarSources of the watch.
+	Vars map[string]interface{}
+	// SecretVars are extra variables resolved from Secrets. They are only
+	// readable by the operator and redacted once the run finishes.
+	SecretVars map[string]interface{}
+	// VaultPassword is the password of the vaulted content of the watch,
+	// read from its vault password secret.
+	VaultPassword []byte
+	// SecretEnv are environment variables resolved from Secrets. Like
+	// SecretVars, they are redacted once the run finishes.
+	SecretEnv map[string]string
+	// Reconcile describes the reconciliation of the run to ansible.
+	Reconcile *ReconcileContext
+}
+
+// watch holds data used to create a mapping of GVK to ansible playbook or role.
+// The mapping is used to compose an ansible operator.
+type watch struct {
+	Version         string         `yaml:"version"`
+	Group           string         `yaml:"group"`
+	Kind            string         `yaml:"kind"`
+	Playbook        string         `yaml:"playbook"`
+	Role            string         `yaml:"role"`
+	ReconcilePeriod string         `yaml:"reconcilePeriod"`
+	ManageStatus    bool           `yaml:"manageStatus"`
+	Finalizer       *Finalizer     `yaml:"finalizer"`
+	Impersonation   *Impersonation `yaml:"impersonation"`
+	Policy          *policy.Policy `yaml:"policy"`
+	AnsibleOptions  AnsibleOptions `yaml:"ansibleOptions"`
+	// AnsibleOptionsOverrides are the ansible options a CR may override
+	// with annotations.
+	AnsibleOptionsOverrides []string                     `yaml:"ansibleOptionsOverrides"`
+	Verbosity               int                          `yaml:"verbosity"`
+	LogEvents               string                       `yaml:"logEvents"`
+	Vars                    []VarSource                  `yaml:"vars"`
+	VaultPasswordSecret     *KeyRef                      `yaml:"vaultPasswordSecret"`
+	VaultPasswordFile       string                       `yaml:"vaultPasswordFile"`
+	Env                     map[string]EnvSource         `yaml:"env"`
+	AnsibleCfg              map[string]map[string]string `yaml:"ansibleCfg"`
+	Selector                *LabelSelector               `yaml:"selector"`
+	Namespaces              []string                     `yaml:"namespaces"`
+	// SnakeCaseParameters is true, false or both.
+	SnakeCaseParameters string `yaml:"snakeCaseParameters"`
+	// CRD is the path of the CRD manifest whose schema lists the camelCase
+	// keys of the CRs.
+	CRD      string          `yaml:"crd"`
+	Backoff  *backoff        `yaml:"backoff"`
+	Schedule *scheduleConfig `yaml:"schedule"`
+	// ReconcileAs is the version of the kind reconciling the CRs of this
+	// version. Empty when this version reconciles them.
+	ReconcileAs string `yaml:"reconcileAs"`
+	// ClusterScoped is whether the CRs are cluster-scoped. When unset, the
+	// scope is discovered from the REST mapping of the kind.
+	ClusterScoped *bool `yaml:"clusterScoped"`
+	// DefaultNamespace is the namespace of the objects created by the runs
+	// of cluster-scoped CRs.
+	DefaultNamespace string `yaml:"defaultNamespace"`
+	// ReadOnly watches resources the operator doesn't own, such as built-in
+	// kinds, without writing their spec or status.
+	ReadOnly bool `yaml:"readOnly"`
+}
+
+// Finalizer - Expose finalizer to be used by a user.
+type Finalizer struct {
+	Name     string                 `yaml:"name"`
+	Playbook string                 `yaml:"playbook"`
+	Role     string                 `yaml:"role"`
+	Vars     map[string]interface{} `yaml:"vars"`
+}
+
+// Impersonation - Expose impersonation to be used by a user. When set, every
+// API request made by a run is authorized as a service account in the
+// namespace of the CR instead of as the operator.
+type Impersonation struct {
+	// ServiceAccount is the name of the service account to impersonate. It
+	// can be overridden per CR with an annotation.
+	ServiceAccount string `yaml:"serviceAccount"`
+}
+
+// UnmarshalYaml - implements the yaml.Unmarshaler interface
+func (w *watch) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	// by default, the operator will manage status
+	w.ManageStatus = true
+	w.Verbosity = defaultVerbosity
+
+	// hide watch data in plain struct to prevent unmarshal from calling
+	// UnmarshalYAML again
+	type plain watch
+
+	return unmarshal((*plain)(w))
+}
+
+// NewFromWatches reads the operator's config file at the provided path.
+func NewFromWatches(path string) (map[schema.GroupVersionKind]Runner, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Error(err, "failed to get config file")
+		return nil, err
+	}
+	watches := []watch{}
+	err = yaml.Unmarshal(b, &watches)
+	if err != nil {
+		log.Error(err, "failed to unmarshal config")
+		return nil, err
+	}
+	err = validateVersions(watches)
+	if err != nil {
+		return nil, err
+	}
+
+	m := map[schema.GroupVersionKind]Runner{}
+	mapped := map[schema.GroupVersionKind]bool{}
+	versions := map[schema.GroupVersionKind]map[string]*runner{}
+	for _, w := range watches {
+		s := schema.GroupVersionKind{
+			Group:   w.Group,
+			Version: w.Version,
+			Kind:    w.Kind,
+		}
+		var reconcilePeriod *time.Duration
+		if w.ReconcilePeriod != "" {
+			d, err := time.ParseDuration(w.ReconcilePeriod)
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse duration: %v - %v, setting to default", w.ReconcilePeriod, err)
+			}
+			reconcilePeriod = &d
+		}
+
+		// The status of a resource the operator doesn't own is left alone,
+		// and so are its finalizers.
+		if w.ReadOnly {
+			if w.Finalizer != nil {
+				return nil, fmt.Errorf("finalizer can't be set with readOnly for %v", s)
+			}
+			w.ManageStatus = false
+		}
+
+		// Check if schema is a duplicate
+		if _, ok := m[s]; ok || mapped[s] {
+			return nil, fmt.Errorf("duplicate GVK: %v", s.String())
+		}
+		// The controller of the reconciling version handles the CRs of the
+		// versions mapped to it.
+		if w.ReconcileAs != "" {
+			log.Info("Version is reconciled as another version", "GVK", s.String(), "ReconcileAs", w.ReconcileAs)
+			if ignored := ignoredOptions(w); len(ignored) != 0 {
+				log.Info("Options of a version reconciled as another version are ignored", "GVK", s.String(), "Options", ignored)
+			}
+			mapped[s] = true
+			v, err := newForVersion(w)
+			if err != nil {
+				return nil, err
+			}
+			if v != nil {
+				reconciling := schema.GroupVersionKind{Group: w.Group, Version: w.ReconcileAs, Kind: w.Kind}
+				if versions[reconciling] == nil {
+					versions[reconciling] = map[string]*runner{}
+				}
+				versions[reconciling][w.Version] = v
+			}
+			continue
+		}
+		var r *runner
+		switch {
+		case w.Playbook != "":
+			r, err = newForPlaybook(w.Playbook, s, w.Finalizer, reconcilePeriod, w.ManageStatus)
+		case w.Role != "":
+			r, err = newForRole(w.Role, s, w.Finalizer, reconcilePeriod, w.ManageStatus)
+		default:
+			return nil, fmt.Errorf("either playbook or role must be defined for %v", s)
+		}
+		if err != nil {
+			return nil, err
+		}
+		err = r.setImpersonation(w.Impersonation)
+		if err != nil {
+			return nil, err
+		}
+		// Without a policy, runs may only write in the namespace of their
+		// CR.
+		r.policy = w.Policy
+		if r.policy == nil {
+			r.policy = &policy.Policy{}
+		}
+		err = validateOverrides(w.AnsibleOptionsOverrides)
+		if err != nil {
+			return nil, fmt.Errorf("%v for %v", err, s)
+		}
+		r.ansibleOptions = w.AnsibleOptions
+		r.ansibleOptionsOverrides = w.AnsibleOptionsOverrides
+		err = validateVerbosity(w.Verbosity)
+		if err != nil {
+			return nil, fmt.Errorf("%v for %v", err, s)
+		}
+		r.verbosity = w.Verbosity
+		if w.LogEvents != "" {
+			l, err := events.ParseLogLevel(w.LogEvents)
+			if err != nil {
+				return nil, fmt.Errorf("%v for %v", err, s)
+			}
+			r.logEvents = &l
+		}
+		err = validateVars(w.Vars)
+		if err != nil {
+			return nil, fmt.Errorf("%v for %v", err, s)
+		}
+		r.vars = w.Vars
+		err = r.setVaultPassword(w.VaultPasswordSecret, w.VaultPasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("%v for %v", err, s)
+		}
+		err = validateEnv(w.Env)
+		if err != nil {
+			return nil, fmt.Errorf("%v for %v", err, s)
+		}
+		r.env = w.Env
+		err = validateAnsibleCfg(w.AnsibleCfg)
+		if err != nil {
+			return nil, fmt.Errorf("%v for %v", err, s)
+		}
+		if len(w.AnsibleCfg) != 0 {
+			if _, ok := w.Env[ansibleConfigEnvVar]; ok {
+				return nil, fmt.Errorf("env %v can't be set with ansibleCfg for %v", ansibleConfigEnvVar, s)
+			}
+			r.ansibleCfg, err = mergeAnsibleCfg(w.AnsibleCfg)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read ansible.cfg: %v for %v", err, s)
+			}
+		}
+		r.selector, err = w.Selector.selector()
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector for %v: %v", s, err)
+		}
+		err = validateNamespaces(w.Namespaces)
+		if err != nil {
+			return nil, fmt.Errorf("%v for %v", err, s)
+		}
+		r.namespaces = w.Namespaces
+		r.parameterKeys, err = parseSnakeCaseParameters(w.SnakeCaseParameters)
+		if err != nil {
+			return nil, fmt.Errorf("%v for %v", err, s)
+		}
+		if w.CRD != "" {
+			r.converter, err = newConverterForCRD(w.CRD, s)
+			if err != nil {
+				return nil, fmt.Errorf("%v for %v", err, s)
+			}
+		}
+		r.backoff, err = w.Backoff.parse()
+		if err != nil {
+			return nil, fmt.Errorf("%v for %v", err, s)
+		}
+		r.schedule, err = w.Schedule.parse()
+		if err != nil {
+			return nil, fmt.Errorf("%v for %v", err, s)
+		}
+		err = validateScope(w.ClusterScoped, w.DefaultNamespace, w.Namespaces)
+		if err != nil {
+			return nil, fmt.Errorf("%v for %v", err, s)
+		}
+		r.clusterScoped = w.ClusterScoped
+		r.defaultNamespace = w.DefaultNamespace
+		r.readOnly = w.ReadOnly
+		m[s] = r
+	}
+	for gvk, v := range versions {
+		m[gvk].(*runner).versions = v
+	}
+	return m, nil
+}
+
+// NewForPlaybook returns a new Runner based on the path to an ansible playbook.
+func NewForPlaybook(path string, gvk schema.GroupVersionKind, finalizer *Finalizer, reconcilePeriod *time.Duration, manageStatus bool) (Runner, error) {
+	r, err := newForPlaybook(path, gvk, finalizer, reconcilePeriod, manageStatus)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func newForPlaybook(path string, gvk schema.GroupVersionKind, finalizer *Finalizer, reconcilePeriod *time.Duration, manageStatus bool) (*runner, error) {
+	if !filepath.IsAbs(path) {
+		return nil, fmt.Errorf("playbook path must be absolute for %v", gvk)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("playbook: %v was not found for %v", path, gvk)
+	}
+	r := &runner{
+		Path: path,
+		GVK:  gvk,
+		cmdFunc: func(ident, inputDirPath string, verbosity int) *exec.Cmd {
+			return exec.Command("ansible-runner", append(verbosityArgs(verbosity), "-p", path, "-i", ident, "run", inputDirPath)...)
+		},
+		reconcilePeriod: reconcilePeriod,
+		manageStatus:    manageStatus,
+		verbosity:       defaultVerbosity,
+	}
+	err := r.addFinalizer(finalizer)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// NewForRole returns a new Runner based on the path to an ansible role.
+func NewForRole(path string, gvk schema.GroupVersionKind, finalizer *Finalizer, reconcilePeriod *time.Duration, manageStatus bool) (Runner, error) {
+	r, err := newForRole(path, gvk, finalizer, reconcilePeriod, manageStatus)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func newForRole(path string, gvk schema.GroupVersionKind, finalizer *Finalizer, reconcilePeriod *time.Duration, manageStatus bool) (*runner, error) {
+	if !filepath.IsAbs(path) {
+		return nil, fmt.Errorf("role path must be absolute for %v", gvk)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("role path: %v was not found for %v", path, gvk)
+	}
+	path = strings.TrimRight(path, "/")
+	r := &runner{
+		Path: path,
+		GVK:  gvk,
+		cmdFunc: func(ident, inputDirPath string, verbosity int) *exec.Cmd {
+			rolePath, roleName := filepath.Split(path)
+			return exec.Command("ansible-runner", append(verbosityArgs(verbosity), "--role", roleName, "--roles-path", rolePath, "--hosts", "localhost", "-i", ident, "run", inputDirPath)...)
+		},
+		reconcilePeriod: reconcilePeriod,
+		manageStatus:    manageStatus,
+		verbosity:       defaultVerbosity,
+	}
+	err := r.addFinalizer(finalizer)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// runner - implements the Runner interface for a GVK that's being watched.
+type runner struct {
+	Path             string                  // path on disk to a playbook or role depending on what cmdFunc expects
+	GVK              schema.GroupVersionKind // GVK being watched that corresponds to the Path
+	Finalizer        *Finalizer
+	cmdFunc          func(ident, inputDirPath string, verbosity int) *exec.Cmd // returns a Cmd that runs ansible-runner
+	finalizerCmdFunc func(ident, inputDirPath string, verbosity int) *exec.Cmd
+	reconcilePeriod  *time.Duration
+	manageStatus     bool
+	impersonation    *Impersonation
+	policy           *policy.Policy
+	// ansibleOptions are the default options of the watch, the overrides
+	// list which of them a CR may override.
+	ansibleOptions          AnsibleOptions
+	ansibleOptionsOverrides []string
+	verbosity               int
+	logEvents               *events.LogLevel
+	vars                    []VarSource
+	// vaultPasswordSecret and vaultPasswordFile are the mutually exclusive
+	// sources of the vault password of the watch.
+	vaultPasswordSecret *KeyRef
+	vaultPasswordFile   string
+	env                 map[string]EnvSource
+	ansibleCfg          map[string]map[string]string
+	selector            labels.Selector
+	namespaces          []string
+	parameterKeys       parameterKeys
+	converter           *paramconv.Converter
+	backoff             *Backoff
+	schedule            *Schedule
+	clusterScoped       *bool
+	defaultNamespace    string
+	readOnly            bool
+	// versions are the runners of the playbooks or roles selected by the
+	// versions mapped to this one, by version.
+	versions map[string]*runner
+	// collisionsLogged is the generation of each CR whose spec keys were
+	// last reported to collide, by UID, so that they are logged once per
+	// change. It is bounded, since deleted CRs are never reported again.
+	collisionsMutex  sync.Mutex
+	collisionsLogged *simplelru.LRU
+}
+
+func (r *runner) Run(ident string, u *unstructured.Unstructured, kubeconfig string, opts RunOptions) (RunResult, error) {
+	if u.GetDeletionTimestamp() != nil && !r.isFinalizerRun(u) {
+		return nil, errors.New("resource has been deleted, but no finalizer was matched, skipping reconciliation")
+	}
+	logger := log.WithValues(
+		"job", ident,
+		"name", u.GetName(),
+		"namespace", u.GetNamespace(),
+		"gvk", r.GVK.String(),
+	)
+
+	// The finalizer always runs completely, so only the check mode of a dry
+	// run applies to it.
+	ansibleOptions := AnsibleOptions{}
+	if !r.isFinalizerRun(u) {
+		var err error
+		ansibleOptions, err = r.ansibleOptionsFor(u)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ansibleOptions.Check = ansibleOptions.Check || opts.DryRun
+	verbosity, err := r.verbosityFor(u)
+	if err != nil {
+		return nil, err
+	}
+
+	// start the event receiver. We'll check errChan for an error after
+	// ansible-runner exits.
+	errChan := make(chan error, 1)
+	receiver, err := eventapi.New(ident, errChan)
+	if err != nil {
+		return nil, err
+	}
+	inputDir := inputdir.InputDir{
+		Path:       filepath.Join("/tmp/ansible-operator/runner/", r.GVK.Group, r.GVK.Version, r.GVK.Kind, inputDirNamespace(u), u.GetName()),
+		Parameters: r.makeParameters(u, opts),
+		EnvVars:    r.envVarsFor(kubeconfig, opts),
+		Settings: map[string]string{
+			"runner_http_url":  receiver.SocketPath,
+			"runner_http_path": receiver.URLPath,
+		},
+		CmdLine: ansibleOptions.cmdLine(),
+	}
+	for k := range opts.SecretVars {
+		inputDir.SecretParameters = append(inputDir.SecretParameters, k)
+	}
+	// The data of a watched Secret is handled like a secret variable.
+	if r.GVK.Group == "" && r.GVK.Kind == "Secret" {
+		inputDir.SecretParameters = append(inputDir.SecretParameters, r.objectParameter())
+	}
+	for k := range opts.SecretEnv {
+		inputDir.SecretEnvVars = append(inputDir.SecretEnvVars, k)
+	}
+	if len(r.ansibleCfg) != 0 {
+		inputDir.AnsibleCfg = ansibleCfg(r.ansibleCfg)
+		// ANSIBLE_CONFIG of the operator's environment would take
+		// precedence over the config of the project.
+		inputDir.EnvVars[ansibleConfigEnvVar] = inputDir.AnsibleCfgPath()
+	}
+	switch {
+	case r.vaultPasswordFile != "":
+		inputDir.CmdLine = append(inputDir.CmdLine, "--vault-password-file", shellQuote(r.vaultPasswordFile))
+	case len(opts.VaultPassword) != 0:
+		inputDir.VaultPassword = opts.VaultPassword
+		inputDir.CmdLine = append(inputDir.CmdLine, "--vault-password-file", shellQuote(inputDir.VaultPasswordPath()))
+	}
+	run := r.runnerFor(u)
+	// If Path is a dir, assume it is a role path. Otherwise assume it's a
+	// playbook path
+	fi, err := os.Lstat(run.Path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		inputDir.PlaybookPath = run.Path
+	}
+	err = inputDir.Write()
+	if err != nil {
+		if err := inputDir.RemoveVaultPassword(); err != nil {
+			logger.Error(err, "failed to remove vault password")
+		}
+		return nil, err
+	}
+
+	go func() {
+		var dc *exec.Cmd
+		switch {
+		case r.isFinalizerRun(u) && (r.Finalizer.Playbook != "" || r.Finalizer.Role != ""):
+			logger.V(1).Info("Resource is marked for deletion, running finalizer", "Finalizer", r.Finalizer.Name)
+			dc = r.finalizerCmdFunc(ident, inputDir.Path, verbosity)
+		case r.isFinalizerRun(u):
+			// The finalizer runs the playbook or role of the version.
+			logger.V(1).Info("Resource is marked for deletion, running finalizer", "Finalizer", r.Finalizer.Name)
+			dc = run.cmdFunc(ident, inputDir.Path, verbosity)
+		default:
+			dc = run.cmdFunc(ident, inputDir.Path, verbosity)
+		}
+
+		output, err := dc.CombinedOutput()
+		if err != nil {
+			logger.Error(err, string(output))
+		} else {
+			logger.Info("ansible-runner exited successfully")
+		}
+		if err := inputDir.RedactSecrets(); err != nil {
+			logger.Error(err, "failed to redact secrets")
+		}
+		if err := inputDir.RemoveVaultPassword(); err != nil {
+			logger.Error(err, "failed to remove vault password")
+		}
+
+		receiver.Close()
+		err = <-errChan
+		// http.Server returns this in the case of being closed cleanly
+		if err != nil && err != http.ErrServerClosed {
+			logger.Error(err, "error from event api")
+		}
+	}()
+	return &runResult{
+		events:         receiver.Events,
+		inputDir:       &inputDir,
+		ident:          ident,
+		ansibleOptions: ansibleOptions,
+		verbosity:      verbosity,
+	}, nil
+}
+
+// GetReconcilePeriod - new reconcile period.
+func (r *runner) GetReconcilePeriod() (time.Duration, bool) {
+	if r.reconcilePeriod == nil {
+		return time.Duration(0), false
+	}
+	return *r.reconcilePeriod, true
+}
+
+// GetManageStatus - get the manage status
+func (r *runner) GetManageStatus() bool {
+	return r.manageStatus
+}
+
+// GetImpersonation - get the default service account to impersonate and
+// whether impersonation is enabled.
+func (r *runner) GetImpersonation() (string, bool) {
+	if r.impersonation != nil {
+		return r.impersonation.ServiceAccount, true
+	}
+	return "", false
+}
+
+// GetPolicy - get the policy restricting the writes of a run.
+func (r *runner) GetPolicy() *policy.Policy {
+	return r.policy
+}
+
+// GetVerbosity - get the default verbosity of ansible for the watch.
+func (r *runner) GetVerbosity() int {
+	return r.verbosity
+}
+
+// GetLogEvents - get the level at which the events of the watch are logged,
+// if the watch sets one.
+func (r *runner) GetLogEvents() (events.LogLevel, bool) {
+	if r.logEvents == nil {
+		return events.Tasks, false
+	}
+	return *r.logEvents, true
+}
+
+// GetVars - get the extra variables the watch reads from Secrets and
+// ConfigMaps.
+func (r *runner) GetVars() []VarSource {
+	return r.vars
+}
+
+// GetVaultPasswordSecret - get the secret holding the vault password of the
+// watch, if any.
+func (r *runner) GetVaultPasswordSecret() *KeyRef {
+	return r.vaultPasswordSecret
+}
+
+// GetEnv - get the environment variables the watch sets for its runs.
+func (r *runner) GetEnv() map[string]EnvSource {
+	return r.env
+}
+
+// GetSelector - get the selector of the CRs the watch reconciles.
+func (r *runner) GetSelector() labels.Selector {
+	if r.selector == nil {
+		return labels.Everything()
+	}
+	return r.selector
+}
+
+// GetNamespaces - get the namespaces the watch reconciles CRs in. Empty
+// means every namespace the operator watches.
+func (r *runner) GetNamespaces() []string {
+	return r.namespaces
+}
+
+// GetBackoff - get how failed runs of the watch are retried, if the watch
+// backs off.
+func (r *runner) GetBackoff() *Backoff {
+	return r.backoff
+}
+
+// GetSchedule - get when the CRs of the watch are reconciled in addition to
+// the changes of their spec.
+func (r *runner) GetSchedule() *Schedule {
+	if r.schedule == nil {
+		return &Schedule{CatchUp: CatchUpOnce}
+	}
+	return r.schedule
+}
+
+// GetClusterScoped - get whether the CRs of the watch are cluster-scoped, if
+// the watch says so.
+func (r *runner) GetClusterScoped() (bool, bool) {
+	if r.clusterScoped == nil {
+		return false, false
+	}
+	return *r.clusterScoped, true
+}
+
+// GetReadOnly - get whether the watch leaves the spec and status of its
+// resources alone.
+func (r *runner) GetReadOnly() bool {
+	return r.readOnly
+}
+
+// GetConverter - get the converter of the keys of the CRD schema of the
+// watch, nil without a crd.
+func (r *runner) GetConverter() *paramconv.Converter {
+	return r.converter
+}
+
+// GetDefaultNamespace - get the namespace of the objects created by the runs
+// of cluster-scoped CRs.
+func (r *runner) GetDefaultNamespace() string {
+	return r.defaultNamespace
+}
+
+func (r *runner) GetFinalizer() (string, bool) {
+	if r.Finalizer != nil {
+		return r.Finalizer.Name, true
+	}
+	return "", false
+}
+
+// runnerFor returns the runner of the playbook or role of the version the CR
+// was applied in, when that version selects one, and r otherwise.
+func (r *runner) runnerFor(u *unstructured.Unstructured) *runner {
+	version, ok := appliedVersion(u)
+	if !ok {
+		return r
+	}
+	if v, ok := r.versions[version]; ok {
+		return v
+	}
+	return r
+}
+
+func (r *runner) isFinalizerRun(u *unstructured.Unstructured) bool {
+	finalizersSet := r.Finalizer != nil && u.GetFinalizers() != nil
+	// The resource is deleted and our finalizer is present, we need to run the finalizer
+	if finalizersSet && u.GetDeletionTimestamp() != nil {
+		for _, f := range u.GetFinalizers() {
+			if f == r.Finalizer.Name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *runner) addFinalizer(finalizer *Finalizer) error {
+	r.Finalizer = finalizer
+	switch {
+	case finalizer == nil:
+		return nil
+	case finalizer.Playbook != "":
+		if !filepath.IsAbs(finalizer.Playbook) {
+			return fmt.Errorf("finalizer playbook path must be absolute for %v", r.GVK)
+		}
+		r.finalizerCmdFunc = func(ident, inputDirPath string, verbosity int) *exec.Cmd {
+			return exec.Command("ansible-runner", append(verbosityArgs(verbosity), "-p", finalizer.Playbook, "-i", ident, "run", inputDirPath)...)
+		}
+	case finalizer.Role != "":
+		if !filepath.IsAbs(finalizer.Role) {
+			return fmt.Errorf("finalizer role path must be absolute for %v", r.GVK)
+		}
+		r.finalizerCmdFunc = func(ident, inputDirPath string, verbosity int) *exec.Cmd {
+			path := strings.TrimRight(finalizer.Role, "/")
+			rolePath, roleName := filepath.Split(path)
+			return exec.Command("ansible-runner", append(verbosityArgs(verbosity), "--role", roleName, "--roles-path", rolePath, "--hosts", "localhost", "-i", ident, "run", inputDirPath)...)
+		}
+	case len(finalizer.Vars) != 0:
+		r.finalizerCmdFunc = r.cmdFunc
+	}
+	return nil
+}
+
+func (r *runner) setImpersonation(impersonation *Impersonation) error {
+	r.impersonation = impersonation
+	if impersonation == nil {
+		return nil
+	}
+	if impersonation.ServiceAccount == "" {
+		impersonation.ServiceAccount = "default"
+	}
+	if errs := validation.IsDNS1123Subdomain(impersonation.ServiceAccount); len(errs) != 0 {
+		return fmt.Errorf("invalid impersonation service account for %v: %v", r.GVK, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// makeParameters - creates the extravars parameters for ansible
+// The resulting structure in json is:
+// { "meta": {
+//      "name": <object_name>,
+//      "namespace": <object_namespace>,
+//      "cluster_scoped": <whether the object is cluster-scoped>,
+//      "default_namespace": <namespace for the objects the run creates>,
+//   },
+//   <cr_spec_fields_as_snake_case, none for a read-only watch>,
+//   ...
+//   _<group_as_snake>_<kind>: {
+//       <cr_object as is
+//   }
+// }
+func (r *runner) makeParameters(u *unstructured.Unstructured, opts RunOptions) map[string]interface{} {
+	s := u.Object["spec"]
+	spec, ok := s.(map[string]interface{})
+	if r.readOnly {
+		// The resource may have no spec, the role reads what it needs
+		// from the object.
+		spec = map[string]interface{}{}
+	} else if !ok {
+		log.Info("spec was not found for CR", "GroupVersionKind", u.GroupVersionKind(), "Namespace", u.GetNamespace(), "Name", u.GetName())
+		spec = map[string]interface{}{}
+	}
+	parameters := map[string]interface{}{}
+	if r.parameterKeys != specKeys {
+		// The keys of the CRD schema convert so that they round-trip.
+		mapToSnake, snakeCollisions := paramconv.MapToSnake, paramconv.SnakeCollisions
+		if r.converter != nil {
+			mapToSnake, snakeCollisions = r.converter.MapToSnake, r.converter.SnakeCollisions
+		}
+		if found := snakeCollisions(spec); len(found) != 0 && r.firstCollisionReport(u) {
+			for key, keys := range found {
+				log.Info("Spec keys collide as snake_case parameter, only the last is used", "Parameter", key, "Keys", keys, "GroupVersionKind", u.GroupVersionKind(), "Namespace", u.GetNamespace(), "Name", u.GetName(), "Generation", u.GetGeneration())
+			}
+		}
+		parameters = mapToSnake(spec)
+	}
+	if r.parameterKeys != snakeCaseKeys {
+		for k, v := range spec {
+			// With both forms, a key that is already snake_case keeps
+			// its converted value.
+			if _, ok := parameters[k]; !ok {
+				parameters[k] = v
+			}
+		}
+	}
+	for _, vars := range []map[string]interface{}{opts.Vars, opts.SecretVars} {
+		for k, v := range vars {
+			parameters[k] = v
+		}
+	}
+	parameters["meta"] = map[string]interface{}{
+		"namespace":         u.GetNamespace(),
+		"name":              u.GetName(),
+		"cluster_scoped":    u.GetNamespace() == "",
+		"default_namespace": NamespaceFor(u, r.defaultNamespace),
+	}
+	if opts.Reconcile != nil {
+		parameters[ReconcileParameter] = opts.Reconcile
+	}
+	parameters[r.objectParameter()] = u.Object
+	if r.isFinalizerRun(u) {
+		for k, v := range r.Finalizer.Vars {
+			parameters[k] = v
+		}
+	}
+	return parameters
+}
+
+// firstCollisionReport - whether the colliding spec keys of the CR are
+// reported for the first time for its generation.
+func (r *runner) firstCollisionReport(u *unstructured.Unstructured) bool {
+	r.collisionsMutex.Lock()
+	defer r.collisionsMutex.Unlock()
+	if r.collisionsLogged == nil {
+		r.collisionsLogged, _ = simplelru.NewLRU(maxCollisionsLogged, nil)
+	}
+	if g, ok := r.collisionsLogged.Get(u.GetUID()); ok && g.(int64) == u.GetGeneration() {
+		return false
+	}
+	r.collisionsLogged.Add(u.GetUID(), u.GetGeneration())
+	return true
+}
+
+// objectParameter - the parameter holding the whole object, e.g.
+// _app_example_com_database, or __configmap for a kind of the core group.
+func (r *runner) objectParameter() string {
+	return fmt.Sprintf("_%v_%v", strings.Replace(r.GVK.Group, ".", "_", -1), strings.ToLower(r.GVK.Kind))
+}
+
+// RunResult - result of a ansible run
+type RunResult interface {
+	// Stdout returns the stdout from ansible-runner if it is available, else an error.
+	Stdout() (string, error)
+	// Events returns the events from ansible-runner if it is available, else an error.
+	Events() <-chan eventapi.JobEvent
+	// AnsibleOptions returns the options ansible was run with.
+	AnsibleOptions() AnsibleOptions
+	// Verbosity returns the verbosity ansible was run with.
+	Verbosity() int
+}
+
+// RunResult facilitates access to information about a run of ansible.
+type runResult struct {
+	// Events is a channel of events from ansible that contain state related
+	// to a run of ansible.
+	events <-chan eventapi.JobEvent
+
+	ident          string
+	inputDir       *inputdir.InputDir
+	ansibleOptions AnsibleOptions
+	verbosity      int
+}
+
+// Stdout returns the stdout from ansible-runner if it is available, else an error.
+func (r *runResult) Stdout() (string, error) {
+	return r.inputDir.Stdout(r.ident)
+}
+
+// Events returns the events from ansible-runner if it is available, else an error.
+func (r *runResult) Events() <-chan eventapi.JobEvent {
+	return r.events
+}
+
+// AnsibleOptions returns the options ansible was run with.
+func (r *runResult) AnsibleOptions() AnsibleOptions {
+	return r.ansibleOptions
+}
+
+// Verbosity returns the verbosity ansible was run with.
+func (r *runResult) Verbosity() int {
+	return r.verbosity
+}
@@ -0,0 +1,72 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"fmt"
+
+	"github.com/water-hole/ansible-operator/pkg/ansible/schedule"
+)
+
+const (
+	// CatchUpOnce - a slot missed while the operator was down runs once as
+	// soon as possible.
+	CatchUpOnce = "once"
+	// CatchUpSkip - a slot missed by more than a minute is skipped.
+	CatchUpSkip = "skip"
+)
+
+// scheduleConfig - the schedule of a watch as written in watches.yaml.
+type scheduleConfig struct {
+	Cron     string `yaml:"cron"`
+	Timezone string `yaml:"timezone"`
+	CatchUp  string `yaml:"catchUp"`
+}
+
+// Schedule - when the CRs of a watch are reconciled, in addition to the
+// changes of their spec.
+type Schedule struct {
+	// Cron is the default schedule, nil if only CRs with a schedule
+	// annotation are scheduled.
+	Cron *schedule.Cron
+	// Timezone is the time zone of the schedule annotations of the CRs
+	// that don't set one.
+	Timezone string
+	// CatchUp is CatchUpOnce or CatchUpSkip.
+	CatchUp string
+}
+
+// parse validates the schedule of a watch and applies the defaults.
+func (s *scheduleConfig) parse() (*Schedule, error) {
+	if s == nil {
+		return &Schedule{CatchUp: CatchUpOnce}, nil
+	}
+	p := &Schedule{Timezone: s.Timezone, CatchUp: s.CatchUp}
+	switch p.CatchUp {
+	case "":
+		p.CatchUp = CatchUpOnce
+	case CatchUpOnce, CatchUpSkip:
+	default:
+		return nil, fmt.Errorf("schedule catchUp must be %v or %v", CatchUpOnce, CatchUpSkip)
+	}
+	if s.Cron != "" {
+		var err error
+		p.Cron, err = schedule.Parse(s.Cron, s.Timezone)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
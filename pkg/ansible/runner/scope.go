@@ -0,0 +1,61 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// clusterScopedDir replaces the namespace in the input directory of a
+// cluster-scoped CR. It isn't a valid namespace name, so the directories of
+// cluster-scoped and namespaced CRs can't collide.
+const clusterScopedDir = "_cluster"
+
+// validateScope checks the scope and the default namespace of a watch.
+func validateScope(clusterScoped *bool, defaultNamespace string, namespaces []string) error {
+	if defaultNamespace != "" {
+		if errs := validation.IsDNS1123Label(defaultNamespace); len(errs) != 0 {
+			return fmt.Errorf("invalid defaultNamespace: %v", strings.Join(errs, ", "))
+		}
+	}
+	if clusterScoped != nil && *clusterScoped && len(namespaces) != 0 {
+		return errors.New("namespaces can't restrict a cluster scoped watch")
+	}
+	return nil
+}
+
+// NamespaceFor - the namespace of the objects a run for the CR works with by
+// default: the namespace of the CR, or defaultNamespace for a cluster-scoped
+// CR. Empty when a cluster-scoped CR has no default namespace.
+func NamespaceFor(u *unstructured.Unstructured, defaultNamespace string) string {
+	if ns := u.GetNamespace(); ns != "" {
+		return ns
+	}
+	return defaultNamespace
+}
+
+// inputDirNamespace is the directory of the namespace of the CR within the
+// input directories of the watch.
+func inputDirNamespace(u *unstructured.Unstructured) string {
+	if ns := u.GetNamespace(); ns != "" {
+		return ns
+	}
+	return clusterScopedDir
+}
@@ -0,0 +1,139 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/water-hole/ansible-operator/pkg/k8sutil"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// VarSource - an extra variable whose value is read from a key of a Secret or
+// a ConfigMap in the namespace of the CR.
+type VarSource struct {
+	// Name is the name of the extra variable.
+	Name            string  `yaml:"name"`
+	SecretKeyRef    *KeyRef `yaml:"secretKeyRef"`
+	ConfigMapKeyRef *KeyRef `yaml:"configMapKeyRef"`
+}
+
+// KeyRef - selects a key of a Secret or a ConfigMap.
+type KeyRef struct {
+	// Name is the name of the Secret or ConfigMap.
+	Name string `yaml:"name"`
+	// NameFromSpec is a dot separated path to a field of the spec of the CR
+	// holding the name of the Secret or ConfigMap. It takes precedence over
+	// Name when the field is set.
+	NameFromSpec string `yaml:"nameFromSpec"`
+	// Key is the key to read.
+	Key string `yaml:"key"`
+	// Optional leaves the variable unset when the object or key doesn't
+	// exist, instead of failing the reconciliation.
+	Optional bool `yaml:"optional"`
+	// Namespace is the namespace of the vault password Secret, which
+	// defaults to the namespace of the operator. The other references
+	// read from the namespace of the CR and can't set it.
+	Namespace string `yaml:"namespace"`
+}
+
+// IsSecret - whether the variable is read from a Secret.
+func (v VarSource) IsSecret() bool {
+	return v.SecretKeyRef != nil
+}
+
+// KeyRef - the reference the variable is read from.
+func (v VarSource) KeyRef() *KeyRef {
+	if v.SecretKeyRef != nil {
+		return v.SecretKeyRef
+	}
+	return v.ConfigMapKeyRef
+}
+
+// ObjectName - the name of the Secret or ConfigMap the variable is read from
+// for the CR. Empty if neither the spec nor the watch name one.
+func (k KeyRef) ObjectName(u *unstructured.Unstructured) string {
+	if k.NameFromSpec != "" {
+		fields := append([]string{"spec"}, strings.Split(k.NameFromSpec, ".")...)
+		if name, ok, _ := unstructured.NestedString(u.Object, fields...); ok && name != "" {
+			return name
+		}
+	}
+	return k.Name
+}
+
+func validateVars(vars []VarSource) error {
+	for _, v := range vars {
+		if v.Name == "" {
+			return fmt.Errorf("vars must have a name")
+		}
+		if (v.SecretKeyRef == nil) == (v.ConfigMapKeyRef == nil) {
+			return fmt.Errorf("var %v must have exactly one of secretKeyRef or configMapKeyRef", v.Name)
+		}
+		ref := v.KeyRef()
+		if ref.Key == "" {
+			return fmt.Errorf("var %v must have a key", v.Name)
+		}
+		if ref.Name == "" && ref.NameFromSpec == "" {
+			return fmt.Errorf("var %v must have a name or nameFromSpec", v.Name)
+		}
+		if ref.Namespace != "" {
+			return fmt.Errorf("var %v can't set a namespace, it is read from the namespace of the CR", v.Name)
+		}
+	}
+	return nil
+}
+
+// defaultVaultPasswordKey is the key of the vault password secret read when
+// the watch doesn't name one.
+const defaultVaultPasswordKey = "password"
+
+func (r *runner) setVaultPassword(secret *KeyRef, file string) error {
+	if secret != nil && file != "" {
+		return fmt.Errorf("only one of vaultPasswordSecret or vaultPasswordFile may be set")
+	}
+	if file != "" && !filepath.IsAbs(file) {
+		return fmt.Errorf("vaultPasswordFile must be absolute")
+	}
+	if secret != nil {
+		// The vault password belongs to the content of the operator, so
+		// the CRs can't choose the Secret it is read from.
+		if secret.Name == "" {
+			return fmt.Errorf("vaultPasswordSecret must have a name")
+		}
+		if secret.NameFromSpec != "" {
+			return fmt.Errorf("vaultPasswordSecret can't set nameFromSpec")
+		}
+		if secret.Key == "" {
+			secret.Key = defaultVaultPasswordKey
+		}
+		if secret.Namespace == "" {
+			ns, err := k8sutil.GetOperatorNamespace()
+			if err != nil {
+				return fmt.Errorf("vaultPasswordSecret needs a namespace outside of a pod: %v", err)
+			}
+			secret.Namespace = ns
+		} else if errs := validation.IsDNS1123Label(secret.Namespace); len(errs) != 0 {
+			return fmt.Errorf("invalid vaultPasswordSecret namespace: %v", strings.Join(errs, ", "))
+		}
+	}
+	r.vaultPasswordSecret = secret
+	r.vaultPasswordFile = file
+	return nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// lastAppliedConfigAnnotation is the annotation kubectl apply records the
+// object it applied in.
+const lastAppliedConfigAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// validateVersions checks that each kind has a single version reconciling
+// its CRs, and that the other versions of the kind map to it with
+// reconcileAs. The versions of a kind are the same stored objects, so two
+// reconciling versions would run twice for each change.
+func validateVersions(watches []watch) error {
+	reconciling := map[schema.GroupKind]string{}
+	for _, w := range watches {
+		if w.ReconcileAs != "" {
+			continue
+		}
+		gk := schema.GroupKind{Group: w.Group, Kind: w.Kind}
+		if v, ok := reconciling[gk]; ok && v != w.Version {
+			return fmt.Errorf("versions %v and %v of %v both reconcile, all but one must set reconcileAs", v, w.Version, gk)
+		}
+		reconciling[gk] = w.Version
+	}
+	for _, w := range watches {
+		if w.ReconcileAs == "" {
+			continue
+		}
+		gvk := schema.GroupVersionKind{Group: w.Group, Version: w.Version, Kind: w.Kind}
+		if w.ReconcileAs == w.Version {
+			return fmt.Errorf("reconcileAs must be another version of the kind for %v", gvk)
+		}
+		v, ok := reconciling[gvk.GroupKind()]
+		if !ok || v != w.ReconcileAs {
+			return fmt.Errorf("reconcileAs: %v is not a reconciling version of the kind for %v", w.ReconcileAs, gvk)
+		}
+		if w.Playbook != "" && w.Role != "" {
+			return fmt.Errorf("only one of playbook and role can be set for %v", gvk)
+		}
+	}
+	return nil
+}
+
+// newForVersion returns the runner of the playbook or role selected by a
+// version mapped to another one, nil when it selects neither. It only runs
+// ansible, the options of the reconciling version apply.
+func newForVersion(w watch) (*runner, error) {
+	gvk := schema.GroupVersionKind{Group: w.Group, Version: w.Version, Kind: w.Kind}
+	switch {
+	case w.Playbook != "":
+		return newForPlaybook(w.Playbook, gvk, nil, nil, false)
+	case w.Role != "":
+		return newForRole(w.Role, gvk, nil, nil, false)
+	}
+	return nil, nil
+}
+
+// appliedVersion returns the version of the kind a CR was last applied in,
+// if kubectl apply recorded it. The API server serves every object in the
+// version it is requested in and keeps no other record of the version an
+// object was written in.
+func appliedVersion(u *unstructured.Unstructured) (string, bool) {
+	a, ok := u.GetAnnotations()[lastAppliedConfigAnnotation]
+	if !ok {
+		return "", false
+	}
+	applied := struct {
+		APIVersion string `json:"apiVersion"`
+	}{}
+	if err := json.Unmarshal([]byte(a), &applied); err != nil {
+		return "", false
+	}
+	gv, err := schema.ParseGroupVersion(applied.APIVersion)
+	if err != nil || gv.Group != u.GroupVersionKind().Group {
+		return "", false
+	}
+	return gv.Version, true
+}
+
+// ignoredOptions returns the yaml names of the options set on a watch mapped
+// to another version, other than its playbook or role. The controller of the
+// reconciling version uses its own options, so they have no effect.
+func ignoredOptions(w watch) []string {
+	unset := watch{
+		Version:      w.Version,
+		Group:        w.Group,
+		Kind:         w.Kind,
+		Playbook:     w.Playbook,
+		Role:         w.Role,
+		ReconcileAs:  w.ReconcileAs,
+		ManageStatus: true,
+		Verbosity:    defaultVerbosity,
+	}
+	ignored := []string{}
+	set, defaults := reflect.ValueOf(w), reflect.ValueOf(unset)
+	for i := 0; i < set.NumField(); i++ {
+		if !reflect.DeepEqual(set.Field(i).Interface(), defaults.Field(i).Interface()) {
+			ignored = append(ignored, set.Type().Field(i).Tag.Get("yaml"))
+		}
+	}
+	return ignored
+}
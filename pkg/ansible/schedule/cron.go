@@ -0,0 +1,267 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package schedule parses cron schedules and computes their next slots.
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron - a schedule in the standard five field cron syntax: minute, hour,
+// day of month, month and day of week.
+type Cron struct {
+	spec     string
+	minute   uint64
+	hour     uint64
+	dom      uint64
+	month    uint64
+	dow      uint64
+	anyDom   bool
+	anyDow   bool
+	location *time.Location
+}
+
+// field - the range of values of a cron field.
+type field struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// Sunday is both 0 and 7.
+	dowField = field{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// macros are the shorthands for common schedules.
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// maxYears bounds the search for the next slot of a schedule that can't
+// happen, like the 30th of February.
+const maxYears = 5
+
+// Parse parses a cron schedule evaluated in the given time zone, "" being
+// UTC. A "CRON_TZ=<zone>" or "TZ=<zone>" prefix overrides the time zone.
+func Parse(spec, timezone string) (*Cron, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		i := strings.Index(spec, " ")
+		if i < 0 {
+			return nil, fmt.Errorf("missing schedule after time zone in %q", spec)
+		}
+		timezone = spec[strings.Index(spec, "=")+1 : i]
+		spec = strings.TrimSpace(spec[i:])
+	}
+	loc := time.UTC
+	if timezone != "" {
+		var err error
+		loc, err = time.LoadLocation(timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone %q: %v", timezone, err)
+		}
+	}
+	expanded := spec
+	if m, ok := macros[spec]; ok {
+		expanded = m
+	}
+	fields := strings.Fields(expanded)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %q must have 5 fields", spec)
+	}
+	c := &Cron{
+		spec:     spec,
+		location: loc,
+		anyDom:   fields[2] == "*" || fields[2] == "?",
+		anyDow:   fields[4] == "*" || fields[4] == "?",
+	}
+	var err error
+	for i, p := range []struct {
+		bits  *uint64
+		field field
+	}{
+		{&c.minute, minuteField},
+		{&c.hour, hourField},
+		{&c.dom, domField},
+		{&c.month, monthField},
+		{&c.dow, dowField},
+	} {
+		*p.bits, err = p.field.parse(fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %v", spec, err)
+		}
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	if c.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("schedule %q never runs", spec)
+	}
+	return c, nil
+}
+
+// parse parses a field: a comma separated list of "*", values and ranges,
+// each with an optional "/step".
+func (f field) parse(s string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(s, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %v %q", f.name, part)
+			}
+			part = part[:i]
+		}
+		var lo, hi int
+		switch {
+		case part == "*" || part == "?":
+			lo, hi = f.min, f.max
+		case strings.Contains(part, "-"):
+			i := strings.Index(part, "-")
+			var err error
+			if lo, err = f.value(part[:i]); err != nil {
+				return 0, err
+			}
+			if hi, err = f.value(part[i+1:]); err != nil {
+				return 0, err
+			}
+		default:
+			var err error
+			if lo, err = f.value(part); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if step > 1 {
+				hi = f.max
+			}
+		}
+		if lo > hi {
+			return 0, fmt.Errorf("invalid range in %v %q", f.name, part)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// value parses a single value of the field, by number or name.
+func (f field) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid %v %q", f.name, s)
+	}
+	return v, nil
+}
+
+// String returns the schedule as it was written, without the time zone.
+func (c *Cron) String() string {
+	return c.spec
+}
+
+// Location returns the time zone the schedule is evaluated in.
+func (c *Cron) Location() *time.Location {
+	return c.location
+}
+
+// Next returns the first slot of the schedule strictly after t, or the zero
+// time if the schedule has none in the next years.
+func (c *Cron) Next(t time.Time) time.Time {
+	// The fields match the wall clock of the time zone, which is walked in
+	// UTC so that the days skipped or repeated by daylight saving time don't
+	// skip or repeat slots.
+	l := t.In(c.location)
+	w := time.Date(l.Year(), l.Month(), l.Day(), l.Hour(), l.Minute(), 0, 0, time.UTC).Add(time.Minute)
+	limit := w.AddDate(maxYears, 0, 0)
+	for w.Before(limit) {
+		if c.month&(1<<uint(w.Month())) == 0 {
+			w = time.Date(w.Year(), w.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !c.dayMatches(w) {
+			w = time.Date(w.Year(), w.Month(), w.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if c.hour&(1<<uint(w.Hour())) == 0 {
+			w = time.Date(w.Year(), w.Month(), w.Day(), w.Hour()+1, 0, 0, 0, time.UTC)
+			continue
+		}
+		if c.minute&(1<<uint(w.Minute())) == 0 {
+			w = w.Add(time.Minute)
+			continue
+		}
+		// A wall clock repeated when the clocks go back is only a slot the
+		// first time.
+		if next := c.instant(w); next.After(t) {
+			return next
+		}
+		w = w.Add(time.Minute)
+	}
+	return time.Time{}
+}
+
+// instant - the instant of the wall clock w in the time zone of the
+// schedule. A wall clock the clocks skip when they go forward is moved past
+// the gap by its length, e.g. 02:30 to 03:30 when 02:00 is skipped to 03:00,
+// rather than the slot being missed.
+func (c *Cron) instant(w time.Time) time.Time {
+	i := time.Date(w.Year(), w.Month(), w.Day(), w.Hour(), w.Minute(), 0, 0, c.location)
+	iw := time.Date(i.Year(), i.Month(), i.Day(), i.Hour(), i.Minute(), 0, 0, time.UTC)
+	if iw.Before(w) {
+		i = i.Add(w.Sub(iw))
+	}
+	return i
+}
+
+// dayMatches - whether the day of t is in the schedule. As in cron, a day
+// matches either restricted day field when both are restricted.
+func (c *Cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case c.anyDom && c.anyDow:
+		return true
+	case c.anyDom:
+		return dow
+	case c.anyDow:
+		return dom
+	default:
+		return dom || dow
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2018 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     string
+		timezone string
+		from     string
+		want     string
+	}{
+		{"hourly macro", "@hourly", "", "2026-10-19T10:20:00Z", "2026-10-19T11:00:00Z"},
+		{"daily macro", "@daily", "", "2026-10-19T10:20:00Z", "2026-10-20T00:00:00Z"},
+		{"midnight macro", "@midnight", "", "2026-10-19T00:00:00Z", "2026-10-20T00:00:00Z"},
+		{"weekly macro", "@weekly", "", "2026-10-19T10:20:00Z", "2026-10-25T00:00:00Z"},
+		{"monthly macro", "@monthly", "", "2026-10-19T10:20:00Z", "2026-11-01T00:00:00Z"},
+		{"yearly macro", "@yearly", "", "2026-10-19T10:20:00Z", "2027-01-01T00:00:00Z"},
+		{"strictly after", "20 10 * * *", "", "2026-10-19T10:20:00Z", "2026-10-20T10:20:00Z"},
+		{"seconds are dropped", "* * * * *", "", "2026-10-19T10:20:59Z", "2026-10-19T10:21:00Z"},
+		{"step in range", "*/15 9-17 * * *", "", "2026-10-19T17:50:00Z", "2026-10-20T09:00:00Z"},
+		{"range with step", "10-30/10 * * * *", "", "2026-10-19T10:20:30Z", "2026-10-19T10:30:00Z"},
+		{"value with step", "5/20 * * * *", "", "2026-10-19T10:26:00Z", "2026-10-19T10:45:00Z"},
+		{"list", "0 6,18 * * *", "", "2026-10-19T10:00:00Z", "2026-10-19T18:00:00Z"},
+		{"names", "0 0 * feb mon", "", "2026-10-19T10:00:00Z", "2027-02-01T00:00:00Z"},
+		{"sunday as 7", "0 0 * * 7", "", "2026-10-19T10:00:00Z", "2026-10-25T00:00:00Z"},
+		{"day of month or day of week, friday first", "0 0 13 * fri", "", "2026-10-19T10:00:00Z", "2026-10-23T00:00:00Z"},
+		{"day of month or day of week, 13th first", "0 0 13 * fri", "", "2026-12-12T10:00:00Z", "2026-12-13T00:00:00Z"},
+		{"day of month or day of week, 1st", "0 0 1 jan-mar mon", "", "2026-10-19T10:00:00Z", "2027-01-01T00:00:00Z"},
+		{"day of month or day of week, monday", "0 0 1 jan-mar mon", "", "2027-01-01T00:00:00Z", "2027-01-04T00:00:00Z"},
+		{"restricted day of week only", "0 0 ? * mon", "", "2026-10-19T10:00:00Z", "2026-10-26T00:00:00Z"},
+		{"time zone", "0 9 * * *", "Asia/Tokyo", "2026-10-19T00:30:00Z", "2026-10-20T00:00:00Z"},
+		{"CRON_TZ prefix", "CRON_TZ=Asia/Tokyo 0 9 * * *", "", "2026-10-19T00:30:00Z", "2026-10-20T00:00:00Z"},
+		{"TZ prefix overrides time zone", "TZ=UTC 0 9 * * *", "Asia/Tokyo", "2026-10-19T00:30:00Z", "2026-10-19T09:00:00Z"},
+		{"leap day", "0 0 29 2 *", "", "2026-10-19T10:00:00Z", "2028-02-29T00:00:00Z"},
+		// 02:00 is skipped to 03:00 on 2026-03-08 in New York.
+		{"skipped hour runs after the gap", "0 2 * * *", "America/New_York", "2026-03-07T12:00:00-05:00", "2026-03-08T03:00:00-04:00"},
+		{"skipped minute runs after the gap", "30 2 * * *", "America/New_York", "2026-03-07T12:00:00-05:00", "2026-03-08T03:30:00-04:00"},
+		{"day after the gap", "0 2 * * *", "America/New_York", "2026-03-08T03:00:00-04:00", "2026-03-09T02:00:00-04:00"},
+		{"every minute over the gap", "* * * * *", "America/New_York", "2026-03-08T01:59:00-05:00", "2026-03-08T03:00:00-04:00"},
+		// 01:00 to 02:00 is repeated on 2026-11-01 in New York.
+		{"repeated hour runs once", "30 1 * * *", "America/New_York", "2026-11-01T01:00:00-04:00", "2026-11-01T01:30:00-04:00"},
+		{"repeated hour isn't run again", "30 1 * * *", "America/New_York", "2026-11-01T01:30:00-04:00", "2026-11-02T01:30:00-05:00"},
+		{"inside the repeated hour", "*/15 * * * *", "America/New_York", "2026-11-01T01:15:00-05:00", "2026-11-01T02:00:00-05:00"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := Parse(tc.spec, tc.timezone)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			from, err := time.Parse(time.RFC3339, tc.from)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := time.Parse(time.RFC3339, tc.want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := c.Next(from); !got.Equal(want) {
+				t.Errorf("Next(%v) = %v, want %v", from, got, want)
+			}
+		})
+	}
+}
+
+func TestNextLimit(t *testing.T) {
+	c, err := Parse("0 0 29 2 *", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 2100 isn't a leap year, the next 29th of February after 2096 is more
+	// than 5 years away.
+	from := time.Date(2096, 3, 1, 0, 0, 0, 0, time.UTC)
+	if got := c.Next(from); !got.IsZero() {
+		t.Errorf("Next(%v) = %v, want the zero time", from, got)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     string
+		timezone string
+	}{
+		{"too few fields", "* * * *", ""},
+		{"too many fields", "* * * * * *", ""},
+		{"unknown macro", "@fortnightly", ""},
+		{"minute out of range", "60 * * * *", ""},
+		{"hour out of range", "0 24 * * *", ""},
+		{"day of month out of range", "0 0 0 * *", ""},
+		{"month out of range", "0 0 1 13 *", ""},
+		{"day of week out of range", "0 0 * * 8", ""},
+		{"unknown name", "0 0 * * mo", ""},
+		{"reversed range", "5-1 * * * *", ""},
+		{"zero step", "*/0 * * * *", ""},
+		{"invalid step", "*/x * * * *", ""},
+		{"never runs", "0 0 30 2 *", ""},
+		{"never runs in april", "0 0 31 4 *", ""},
+		{"unknown time zone", "0 0 * * *", "Nowhere/City"},
+		{"unknown CRON_TZ", "CRON_TZ=Nowhere/City 0 0 * * *", ""},
+		{"CRON_TZ without schedule", "CRON_TZ=UTC", ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if c, err := Parse(tc.spec, tc.timezone); err == nil {
+				t.Errorf("Parse(%q, %q) = %v, want an error", tc.spec, tc.timezone, c)
+			}
+		})
+	}
+}
@@ -4,13 +4,15 @@ import (
 	"context"
 	"time"
 
-	"github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
 )
 
+var log = logf.Log.WithName("reconcile-loop")
+
 // ReconcileLoop - new loop
 type ReconcileLoop struct {
 	Source   chan event.GenericEvent
@@ -44,7 +46,7 @@ func (r *ReconcileLoop) Start() {
 				ul.SetGroupVersionKind(r.GVK)
 				err := r.Client.List(context.Background(), nil, ul)
 				if err != nil {
-					logrus.Warningf("unable to list resources for GV: %v during reconcilation", r.GVK)
+					log.Error(err, "unable to list resources during reconcilation", "gvk", r.GVK.String())
 					continue
 				}
 				for _, u := range ul.Items {
@@ -0,0 +1,24 @@
+package k8sutil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// ServiceAccountNamespaceFile is the file holding the namespace of the
+// service account of the pod, which is the namespace of the operator
+const ServiceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// GetOperatorNamespace returns the namespace the operator runs in
+func GetOperatorNamespace() (string, error) {
+	b, err := ioutil.ReadFile(ServiceAccountNamespaceFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the operator namespace: %v", err)
+	}
+	ns := strings.TrimSpace(string(b))
+	if len(ns) == 0 {
+		return "", fmt.Errorf("%s is empty", ServiceAccountNamespaceFile)
+	}
+	return ns, nil
+}
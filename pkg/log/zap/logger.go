@@ -0,0 +1,139 @@
+package zap
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/zapr"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+var (
+	zapFlagSet *pflag.FlagSet
+
+	development bool
+	encoderVal  encoderValue
+	levelVal    levelValue
+)
+
+func init() {
+	zapFlagSet = pflag.NewFlagSet("zap", pflag.ExitOnError)
+	zapFlagSet.BoolVar(&development, "zap-devel", false, "enable zap development mode (changes defaults to console encoder, debug log level, and disables sampling)")
+	zapFlagSet.Var(&encoderVal, "zap-encoder", "zap log encoding ('json' or 'console')")
+	zapFlagSet.Var(&levelVal, "zap-level", "zap log level (one of 'debug', 'info', 'error' or any integer value > 0)")
+}
+
+// FlagSet returns the flags that configure the logger.
+func FlagSet() *pflag.FlagSet {
+	return zapFlagSet
+}
+
+// Logger returns a logger configured by the flags of FlagSet. It must be
+// called after the flags have been parsed.
+func Logger() logr.Logger {
+	var enc zapcore.Encoder
+	var lvl zap.AtomicLevel
+	var opts []zap.Option
+	sink := zapcore.AddSync(os.Stderr)
+
+	if development {
+		enc = zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
+		lvl = zap.NewAtomicLevelAt(zap.DebugLevel)
+		opts = append(opts, zap.Development(), zap.AddStacktrace(zap.ErrorLevel))
+	} else {
+		enc = zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+		lvl = zap.NewAtomicLevelAt(zap.InfoLevel)
+		opts = append(opts, zap.AddStacktrace(zap.WarnLevel),
+			zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+				return zapcore.NewSampler(core, time.Second, 100, 100)
+			}))
+	}
+	if encoderVal.set {
+		enc = encoderVal.newEncoder(development)
+	}
+	if levelVal.set {
+		lvl = zap.NewAtomicLevelAt(levelVal.level)
+	}
+	opts = append(opts, zap.ErrorOutput(sink))
+
+	log := zap.New(zapcore.NewCore(&logf.KubeAwareEncoder{Encoder: enc, Verbose: development}, sink, lvl))
+	log = log.WithOptions(opts...)
+	return zapr.NewLogger(log)
+}
+
+type encoderValue struct {
+	set     bool
+	encoder string
+}
+
+func (v *encoderValue) Set(e string) error {
+	e = strings.ToLower(e)
+	if e != "json" && e != "console" {
+		return fmt.Errorf("unknown encoder %q", e)
+	}
+	v.set = true
+	v.encoder = e
+	return nil
+}
+
+func (v *encoderValue) newEncoder(development bool) zapcore.Encoder {
+	encCfg := zap.NewProductionEncoderConfig()
+	if development {
+		encCfg = zap.NewDevelopmentEncoderConfig()
+	}
+	if v.encoder == "console" {
+		return zapcore.NewConsoleEncoder(encCfg)
+	}
+	return zapcore.NewJSONEncoder(encCfg)
+}
+
+func (v *encoderValue) String() string {
+	return v.encoder
+}
+
+func (v *encoderValue) Type() string {
+	return "encoder"
+}
+
+type levelValue struct {
+	set   bool
+	level zapcore.Level
+}
+
+func (v *levelValue) Set(l string) error {
+	v.set = true
+	lower := strings.ToLower(l)
+	switch lower {
+	case "debug", "info", "error":
+		return v.level.Set(lower)
+	}
+	// logr verbosity levels map to negative zap levels, so V(1) is logged
+	// at level 1 and above.
+	i, err := strconv.Atoi(lower)
+	if err != nil || i <= 0 {
+		return fmt.Errorf("invalid log level %q", l)
+	}
+	v.level = zapcore.Level(-1 * i)
+	return nil
+}
+
+func (v *levelValue) String() string {
+	if !v.set {
+		return ""
+	}
+	if v.level < zapcore.DebugLevel {
+		return strconv.Itoa(int(-v.level))
+	}
+	return v.level.String()
+}
+
+func (v *levelValue) Type() string {
+	return "level"
+}